@@ -0,0 +1,56 @@
+package search
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// AccessField is the record field holding the users allowed to see a
+// document, separated by commas. Documents without it are public.
+const AccessField = "Access"
+
+// AnyUser allows any authenticated user
+const AnyUser = "*"
+
+// AccessRule restricts the documents whose path matches to the listed users
+type AccessRule struct {
+	Path  *regexp.Regexp
+	Users []string
+}
+
+// Access returns the users allowed to see the path by the first matching
+// rule, and if any rule matched
+func (c *Config) Access(path string) (string, bool) {
+	for _, rule := range c.AccessRules {
+		if rule.Path.MatchString(path) {
+			return strings.Join(rule.Users, ","), true
+		}
+	}
+	return "", false
+}
+
+// Visible returns true if the user can see a document with the given access
+func Visible(access, user string) bool {
+	if access == "" {
+		return true
+	}
+	if user == "" {
+		return false
+	}
+	for _, allowed := range strings.Split(access, ",") {
+		if allowed == AnyUser || allowed == user {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteUser returns the user authenticated for the request by basicauth
+// (or any middleware setting the remote user), or an empty string
+func RemoteUser(r *http.Request) string {
+	user, _ := r.Context().Value(httpserver.RemoteUserCtxKey).(string)
+	return user
+}
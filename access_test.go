@@ -0,0 +1,93 @@
+package search_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccess(t *testing.T) {
+	Convey("Given documents with visibility rules", t, func() {
+		conf := search.Config{
+			AccessRules: []search.AccessRule{
+				{Path: search.ConvertToRegExp([]string{"^/admin/"})[0], Users: []string{"alice", "bob"}},
+				{Path: search.ConvertToRegExp([]string{"^/members/"})[0], Users: []string{search.AnyUser}},
+			},
+		}
+
+		Convey("Should resolve the users of the first matching rule", func() {
+			access, ok := conf.Access("/admin/users.html")
+			So(ok, ShouldBeTrue)
+			So(access, ShouldEqual, "alice,bob")
+
+			_, ok = conf.Access("/public.html")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should only show protected documents to allowed users", func() {
+			So(search.Visible("", ""), ShouldBeTrue)
+			So(search.Visible("alice,bob", ""), ShouldBeFalse)
+			So(search.Visible("alice,bob", "carol"), ShouldBeFalse)
+			So(search.Visible("alice,bob", "bob"), ShouldBeTrue)
+			So(search.Visible(search.AnyUser, "carol"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestCapturedAccess(t *testing.T) {
+	Convey("Given a dynamic page served to authenticated users", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+		site.config.Endpoint = "/search"
+
+		version := "First version"
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<title>Page</title><p>" + version + "</p>"))
+			return http.StatusOK, nil
+		})
+		serve := func(user string) {
+			r := httptest.NewRequest(http.MethodGet, "/page", nil)
+			if user != "" {
+				r = r.WithContext(context.WithValue(r.Context(), httpserver.RemoteUserCtxKey, user))
+			}
+			site.search.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		indexed := func() (body, access string) {
+			record := site.index.Record("/page")
+			defer site.index.Kill(record)
+			if !record.Load() {
+				return "", ""
+			}
+			return string(record.Body()), record.Field(search.AccessField)
+		}
+
+		serve("alice")
+		So(eventually(func() bool { return site.indexed("/page") }), ShouldBeTrue)
+
+		Convey("Should only show it to the user it was served to", func() {
+			_, access := indexed()
+			So(access, ShouldEqual, "alice")
+			So(search.Visible(access, "bob"), ShouldBeFalse)
+		})
+
+		Convey("Should not make it public when it's indexed again anonymously", func() {
+			version = "Second version"
+			serve("")
+
+			var access string
+			So(eventually(func() bool {
+				var body string
+				body, access = indexed()
+				return strings.Contains(body, "Second version")
+			}), ShouldBeTrue)
+			So(access, ShouldEqual, "alice")
+		})
+	})
+}
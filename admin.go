@@ -0,0 +1,308 @@
+package search
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// defaultListLimit and maxListLimit bound the page size of document listings
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// ServeAdmin is the HTTP handler for the admin endpoint. Every request must
+// carry the configured token as `Authorization: Bearer <token>`, or be
+// authenticated by basicauth when no token is configured.
+//
+//	POST   <admin>/scan                      rescan the site root
+//	GET    <admin>/documents?offset=&limit=  list documents
+//	GET    <admin>/document?path=            fetch a stored document
+//	POST   <admin>/document?path=            reindex a static file
+//	DELETE <admin>/document?path=            delete a document
+//	POST   <admin>/purge                     delete the documents of the site
+//	GET    <admin>/snapshot                  download an archive of the index
+//	GET    <admin>/export                    export documents as JSON lines
+//	POST   <admin>/import                    import documents from JSON lines
+//	GET    <admin>/status                    index statistics and scan status
+//	GET    <admin>/duplicates                list near-duplicate clusters
+func (s *Search) ServeAdmin(w http.ResponseWriter, r *http.Request) (int, error) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="search"`)
+		return http.StatusUnauthorized, nil
+	}
+
+	route := strings.Trim(strings.TrimPrefix(r.URL.Path, s.Config.AdminEndpoint), "/")
+
+	switch route {
+	case "scan":
+		if r.Method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		go ScanToPipe(s.Config.SiteRoot, s.Pipeline, s.Indexer)
+		return writeJSON(w, http.StatusAccepted, map[string]string{"status": "scanning"})
+	case "documents":
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		return s.listDocuments(w, r)
+	case "document":
+		return s.serveDocument(w, r)
+	case "purge":
+		if r.Method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		deleted, err := s.purge()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	case "snapshot":
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+s.Config.HostName+`.tar.gz"`)
+		body := &countingWriter{w: w}
+		if err := s.Indexer.Snapshot(body); err != nil {
+			return streamError(body, "snapshot", err)
+		}
+		return http.StatusOK, nil
+	case "export":
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+s.Config.HostName+`.jsonl"`)
+		body := &countingWriter{w: w}
+		if _, err := indexer.Export(s.Indexer, body); err != nil {
+			return streamError(body, "export", err)
+		}
+		return http.StatusOK, nil
+	case "import":
+		if r.Method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		imported, err := indexer.Import(s.Indexer, r.Body)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return writeJSON(w, http.StatusAccepted, map[string]int{"imported": imported})
+	case "status":
+		status, err := s.Status()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return writeJSON(w, http.StatusOK, status)
+	case "duplicates":
+		return writeJSON(w, http.StatusOK, s.Pipeline.Duplicates.Clusters())
+	}
+
+	return http.StatusNotFound, nil
+}
+
+// bearerPrefix starts the Authorization header of token requests
+const bearerPrefix = "Bearer "
+
+// authorized checks the request's token against the configured one
+func (s *Search) authorized(r *http.Request) bool {
+	if s.Config.AdminToken == "" {
+		return false
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return false
+	}
+	token := auth[len(bearerPrefix):]
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.AdminToken)) == 1
+}
+
+// documentList is the response of the documents listing
+type documentList struct {
+	Total     uint64
+	Offset    int
+	Documents []documentSummary
+}
+
+type documentSummary struct {
+	Path     string
+	Title    string
+	Modified time.Time
+	Indexed  time.Time
+}
+
+func (s *Search) listDocuments(w http.ResponseWriter, r *http.Request) (int, error) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	total, err := s.Indexer.Count()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	records, err := s.Indexer.List(offset, limit)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	list := documentList{
+		Total:     total,
+		Offset:    offset,
+		Documents: make([]documentSummary, len(records)),
+	}
+	for i, record := range records {
+		list.Documents[i] = documentSummary{
+			Path:     record.Path(),
+			Title:    record.Title(),
+			Modified: record.Modified(),
+			Indexed:  record.Indexed(),
+		}
+		s.Indexer.Kill(record)
+	}
+
+	return writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Search) serveDocument(w http.ResponseWriter, r *http.Request) (int, error) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		return http.StatusBadRequest, nil
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		record, err := s.Indexer.Get(path)
+		if err == indexer.ErrNotFound {
+			return http.StatusNotFound, nil
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		doc := indexer.NewDocument(record)
+		s.Indexer.Kill(record)
+		return writeJSON(w, http.StatusOK, doc)
+	case http.MethodPost:
+		if !s.reindex(path) {
+			return http.StatusNotFound, nil
+		}
+		return writeJSON(w, http.StatusAccepted, map[string]string{"status": "reindexing"})
+	case http.MethodDelete:
+		if err := s.Pipeline.Remove(path); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	}
+
+	return http.StatusMethodNotAllowed, nil
+}
+
+// reindex pipes the static file served at path, if there is one, even if it
+// didn't change
+func (s *Search) reindex(path string) bool {
+	root, err := filepath.Abs(s.Config.SiteRoot)
+	if err != nil {
+		return false
+	}
+
+	fullPath := filepath.Join(root, filepath.FromSlash(filepath.Clean("/"+path)))
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	reqPath := filepath.ToSlash(filepath.Clean("/" + path))
+	if s.Pipeline.Crawl != nil {
+		s.Pipeline.Crawl.Remove(reqPath)
+	}
+	return PipeFile(reqPath, fullPath, info, s.Pipeline, s.Indexer) != nil
+}
+
+// purge deletes the documents this site indexed
+func (s *Search) purge() (deleted int, err error) {
+	if s.Pipeline.Crawl != nil {
+		s.Pipeline.Crawl.Reset()
+	}
+
+	var paths []string
+	for offset := 0; ; offset += maxListLimit {
+		records, err := s.Indexer.List(offset, maxListLimit)
+		if err != nil {
+			return deleted, err
+		}
+		for _, record := range records {
+			if s.Pipeline.owns(record) {
+				paths = append(paths, record.Path())
+			}
+			s.Indexer.Kill(record)
+		}
+		if len(records) < maxListLimit {
+			break
+		}
+	}
+
+	for _, path := range paths {
+		if err := s.Pipeline.Remove(path); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamError reports an error that happened while streaming a response. Once
+// the body started, the response is committed: the error is only logged.
+func streamError(body *countingWriter, what string, err error) (int, error) {
+	if body.n == 0 {
+		return http.StatusInternalServerError, err
+	}
+	log.Printf("[ERROR] search: %s interrupted: %v", what, err)
+	return 0, nil
+}
+
+// writeJSON writes v as the JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	jresp, err := json.Marshal(v)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jresp)
+	return status, nil
+}
@@ -0,0 +1,108 @@
+package search_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	"github.com/pedronasser/caddy-search/indexer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdminAuth(t *testing.T) {
+	Convey("Given an admin endpoint", t, func() {
+		s := &search.Search{Config: &search.Config{AdminEndpoint: "/search-admin"}}
+		serve := func(authorization, user string) int {
+			r := httptest.NewRequest(http.MethodGet, "/search-admin/unknown", nil)
+			if authorization != "" {
+				r.Header.Set("Authorization", authorization)
+			}
+			if user != "" {
+				r = r.WithContext(context.WithValue(r.Context(), httpserver.RemoteUserCtxKey, user))
+			}
+			status, _ := s.ServeAdmin(httptest.NewRecorder(), r)
+			return status
+		}
+
+		Convey("Should only accept the exact bearer token", func() {
+			s.Config.AdminToken = "secret"
+			So(serve("Bearer secret", ""), ShouldEqual, http.StatusNotFound)
+			So(serve("secret", ""), ShouldEqual, http.StatusUnauthorized)
+			So(serve("Bearer ", ""), ShouldEqual, http.StatusUnauthorized)
+			So(serve("Bearer other", ""), ShouldEqual, http.StatusUnauthorized)
+			So(serve("", "alice"), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Should refuse every request without a token", func() {
+			So(serve("", ""), ShouldEqual, http.StatusUnauthorized)
+			So(serve("Bearer ", ""), ShouldEqual, http.StatusUnauthorized)
+			So(serve("", "alice"), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Should gate the metrics endpoint the same way", func() {
+			s.Config.AdminToken = "secret"
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			status, _ := s.ServeMetrics(httptest.NewRecorder(), r)
+			So(status, ShouldEqual, http.StatusUnauthorized)
+
+			r.Header.Set("Authorization", "Bearer secret")
+			status, _ = s.ServeMetrics(httptest.NewRecorder(), r)
+			So(status, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestAdminSetup(t *testing.T) {
+	Convey("Given an admin endpoint without a token", t, func() {
+		c := caddy.NewTestController(`search {
+			admin /search-admin
+		}`)
+		_, err := search.ParseSearchConfig(c, httpserver.GetConfig(""))
+
+		Convey("Should not be accepted", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// failingSnapshot fails after writing the given part of the archive
+type failingSnapshot struct {
+	indexer.Handler
+	part string
+}
+
+func (f failingSnapshot) Snapshot(w io.Writer) error {
+	io.WriteString(w, f.part)
+	return errors.New("disk error")
+}
+
+func TestAdminSnapshot(t *testing.T) {
+	Convey("Given an admin endpoint whose snapshots fail", t, func() {
+		s := &search.Search{Config: &search.Config{AdminEndpoint: "/search-admin", AdminToken: "secret"}}
+		serve := func() (int, error) {
+			r := httptest.NewRequest(http.MethodGet, "/search-admin/snapshot", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			return s.ServeAdmin(httptest.NewRecorder(), r)
+		}
+
+		Convey("Should report the error before the archive is sent", func() {
+			s.Indexer = failingSnapshot{}
+			status, err := serve()
+			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should only log the error once the archive is being sent", func() {
+			s.Indexer = failingSnapshot{part: "partial archive"}
+			status, err := serve()
+			So(status, ShouldEqual, 0)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,203 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// SourceField is the record field telling where a document comes from:
+// SourceStatic for files read from the site root and SourceDynamic for
+// captured responses
+const SourceField = "Source"
+
+// Sources of the documents
+const (
+	SourceStatic  = "static"
+	SourceDynamic = "dynamic"
+)
+
+// Remove deletes the path from the index and forgets it in the duplicates
+// and the crawl state
+func (p *Pipeline) Remove(path string) error {
+	p.Duplicates.Remove(path)
+	if p.Crawl != nil {
+		p.Crawl.Remove(path)
+	}
+	if err := p.indexer.Delete(path); err != nil {
+		return err
+	}
+	p.Stats.Removed()
+	return nil
+}
+
+// owns returns true if the document was indexed by this site. Documents
+// indexed before sites were recorded belong to every site sharing the index.
+func (p *Pipeline) owns(record indexer.Record) bool {
+	site := record.Field(SiteField)
+	return site == "" || site == p.config.Host
+}
+
+// removeIndexed removes the path if it's in the index and forgets it
+// otherwise. Most gone paths were never indexed: they were ignored, or are
+// unknown pages answered with a 404.
+func (p *Pipeline) removeIndexed(path string) {
+	record, err := p.indexer.Get(path)
+	if err != nil {
+		p.Duplicates.Remove(path)
+		if p.Crawl != nil {
+			p.Crawl.Remove(path)
+		}
+		return
+	}
+	p.indexer.Kill(record)
+
+	if err := p.Remove(path); err != nil {
+		p.Stats.Error(err)
+	}
+}
+
+// removeGone removes the dynamic page of the site at path when a request of
+// the user finds it gone. Pages the user can't see are kept: handlers may
+// answer 404 to the users they hide a page from.
+func (p *Pipeline) removeGone(path, user string) {
+	record, err := p.indexer.Get(path)
+	if err != nil {
+		return
+	}
+	access, ruled := p.config.Access(path)
+	if !ruled {
+		access = record.Field(AccessField)
+	}
+	removable := record.Field(SourceField) == SourceDynamic && p.owns(record) && Visible(access, user)
+	p.indexer.Kill(record)
+
+	if removable {
+		if err := p.Remove(path); err != nil {
+			p.Stats.Error(err)
+		}
+	}
+}
+
+// unindex removes the path from the index if it's in it, but keeps its
+// crawl state: the file is still there, its content just mustn't be indexed
+func (p *Pipeline) unindex(path string) {
+	record, err := p.indexer.Get(path)
+	if err != nil {
+		return
+	}
+	p.indexer.Kill(record)
+
+	p.Duplicates.Remove(path)
+	if err := p.indexer.Delete(path); err != nil {
+		p.Stats.Error(err)
+		return
+	}
+	p.Stats.Removed()
+}
+
+// removeTree removes the static file at path, or the files under it when
+// it was a directory. Files under a directory are only known from the crawl
+// state: without one, they are removed by the next scan.
+func (p *Pipeline) removeTree(path string) {
+	p.removeIndexed(path)
+
+	if p.Crawl == nil {
+		return
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for _, known := range p.Crawl.Paths() {
+		if strings.HasPrefix(known, prefix) {
+			p.removeIndexed(known)
+		}
+	}
+}
+
+// removeMissing removes the static documents a complete scan didn't find
+// among the valid paths of the site root: their file was deleted or renamed,
+// or they are excluded now. Without a crawl state, the static documents are
+// found by listing the index for the ones of this site.
+func (p *Pipeline) removeMissing(seen map[string]bool) {
+	var missing []string
+
+	if p.Crawl != nil {
+		for _, path := range p.Crawl.Paths() {
+			if !seen[path] {
+				missing = append(missing, path)
+			}
+		}
+	} else {
+		for offset := 0; ; offset += maxListLimit {
+			records, err := p.indexer.List(offset, maxListLimit)
+			if err != nil {
+				p.Stats.Error(err)
+				return
+			}
+			for _, record := range records {
+				if record.Field(SourceField) == SourceStatic && p.owns(record) && !seen[record.Path()] {
+					missing = append(missing, record.Path())
+				}
+				p.indexer.Kill(record)
+			}
+			if len(records) < maxListLimit {
+				break
+			}
+		}
+	}
+
+	for _, path := range missing {
+		p.removeIndexed(path)
+	}
+}
+
+// removeStale removes the documents of the site stored the way older versions
+// stored them: dynamic pages under a path that isn't normalized, like the ones
+// captured before parameters were stripped, and documents indexed before
+// their source was recorded that no valid file of the site root backs. Pages
+// are captured again under their normalized path when they are requested,
+// files are indexed again by the scan.
+func (p *Pipeline) removeStale() {
+	var stale []string
+
+	for offset := 0; ; offset += maxListLimit {
+		records, err := p.indexer.List(offset, maxListLimit)
+		if err != nil {
+			p.Stats.Error(err)
+			return
+		}
+		for _, record := range records {
+			if p.owns(record) && p.isStale(record) {
+				stale = append(stale, record.Path())
+			}
+			p.indexer.Kill(record)
+		}
+		if len(records) < maxListLimit {
+			break
+		}
+	}
+
+	for _, path := range stale {
+		p.removeIndexed(path)
+	}
+}
+
+// isStale returns true if the document is stored the way older versions
+// stored it
+func (p *Pipeline) isStale(record indexer.Record) bool {
+	path := record.Path()
+	switch record.Field(SourceField) {
+	case SourceDynamic:
+		normalized, ok := p.config.ResolvePath("/", path)
+		return !ok || normalized != path
+	case SourceStatic:
+		return false
+	}
+
+	if !p.ValidatePath(path) {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(p.config.SiteRoot, filepath.FromSlash(path)))
+	return err != nil || !info.Mode().IsRegular()
+}
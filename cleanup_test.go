@@ -0,0 +1,134 @@
+package search_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoveGone(t *testing.T) {
+	Convey("Given an indexed dynamic page", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		status, header := http.StatusOK, http.Header{}
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			switch status {
+			case http.StatusOK:
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<title>Page</title><p>Dynamic page</p>"))
+			case http.StatusGone:
+				// written by the handler instead of returned
+				w.WriteHeader(status)
+				return 0, nil
+			}
+			return status, nil
+		})
+		serve := func() {
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+		}
+
+		serve()
+		So(eventually(func() bool { return site.indexed("/page") }), ShouldBeTrue)
+
+		Convey("Should remove it when it's not found", func() {
+			status = http.StatusNotFound
+			serve()
+			So(eventually(func() bool { return !site.indexed("/page") }), ShouldBeTrue)
+		})
+
+		Convey("Should remove it when it's gone", func() {
+			status = http.StatusGone
+			serve()
+			So(eventually(func() bool { return !site.indexed("/page") }), ShouldBeTrue)
+		})
+
+		Convey("Should remove it when it mustn't be indexed anymore", func() {
+			header.Set("X-Robots-Tag", "noindex")
+			serve()
+			So(eventually(func() bool { return !site.indexed("/page") }), ShouldBeTrue)
+		})
+
+		Convey("Should keep it when it's gone for another user only", func() {
+			site.index.Delete("/page")
+			r := httptest.NewRequest(http.MethodGet, "/page", nil)
+			r = r.WithContext(context.WithValue(r.Context(), httpserver.RemoteUserCtxKey, "alice"))
+			site.search.ServeHTTP(httptest.NewRecorder(), r)
+			So(eventually(func() bool { return site.indexed("/page") }), ShouldBeTrue)
+
+			status = http.StatusNotFound
+			serve()
+			site.settle()
+			So(site.indexed("/page"), ShouldBeTrue)
+		})
+
+		Convey("Should keep it on other errors", func() {
+			status = http.StatusInternalServerError
+			serve()
+			site.settle()
+			So(site.indexed("/page"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given indexed static files", t, func() {
+		site := newTestSite(map[string]string{
+			"kept.html":    "<title>Kept</title><p>Kept page</p>",
+			"deleted.html": "<title>Deleted</title><p>Deleted page</p>",
+		})
+		Reset(site.close)
+
+		search.ScanToPipe(site.root, site.pipeline, site.index)
+		site.settle()
+		So(site.indexed("/deleted.html"), ShouldBeTrue)
+
+		Convey("Should not remove them when a handler doesn't find them", func() {
+			site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+				return http.StatusNotFound, nil
+			})
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/kept.html", nil))
+			site.settle()
+			So(site.indexed("/kept.html"), ShouldBeTrue)
+		})
+
+		Convey("Should remove the files a scan doesn't find anymore", func() {
+			os.Remove(filepath.Join(site.root, "deleted.html"))
+			search.ScanToPipe(site.root, site.pipeline, site.index)
+			site.settle()
+			So(site.indexed("/deleted.html"), ShouldBeFalse)
+			So(site.indexed("/kept.html"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given documents indexed before their source was recorded", t, func() {
+		site := newTestSite(map[string]string{
+			"kept.html": "<title>Kept</title><p>Kept page</p>",
+		})
+		Reset(site.close)
+
+		for _, path := range []string{"/kept.html", "/gone.html", "/page?id=1"} {
+			record := site.index.Record(path)
+			record.SetBody([]byte("Indexed by an older version"))
+			site.index.Pipe(record)
+		}
+		site.settle()
+		So(eventually(func() bool { return site.indexed("/page?id=1") }), ShouldBeTrue)
+
+		Convey("Should remove the ones no file backs at startup", func() {
+			search.ScanToPipe(site.root, site.pipeline, site.index)
+			site.settle()
+			So(site.indexed("/kept.html"), ShouldBeTrue)
+			So(site.indexed("/gone.html"), ShouldBeFalse)
+			So(site.indexed("/page?id=1"), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+)
+
+// stringsFlag is a flag that can be given multiple times
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// build indexes a site root the way the middleware does
+func build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	idx := newIndexFlags(flags)
+	root := flags.String("root", ".", "site root to index")
+	var include, exclude stringsFlag
+	flags.Var(&include, "include", "regexp of paths to index, like +path (repeatable, default: ^/)")
+	flags.Var(&exclude, "exclude", "regexp of paths not to index, like -path (repeatable)")
+	full := flags.Bool("full", false, "read every file again, even the unchanged ones")
+	flags.Parse(args)
+
+	if _, err := os.Stat(*root); err != nil {
+		return errors.New("invalid root directory")
+	}
+	if len(include) == 0 {
+		include = stringsFlag{"^/"}
+	}
+
+	config := search.NewConfig(search.SiteHost(*idx.host), *root)
+	config.IndexDirectory = *idx.datadir
+	config.Engine = *idx.engine
+	config.IncludePaths = search.ConvertToRegExp(include)
+	config.ExcludePaths = search.ConvertToRegExp(exclude)
+	if *idx.name != "" {
+		config.HostName = *idx.name
+	}
+
+	if err := os.MkdirAll(config.IndexDirectory, os.ModePerm); err != nil {
+		return err
+	}
+
+	index, err := idx.open()
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := search.NewPipeline(config, index)
+	if err != nil {
+		index.Close()
+		return err
+	}
+	if !*full {
+		pipeline.Crawl = search.LoadCrawlState(config.CrawlStateFile(), config.Fingerprint())
+	}
+
+	start := time.Now()
+	search.ScanToPipe(config.SiteRoot, pipeline, index)
+
+	drained := false
+	for !drained {
+		drained = pipeline.Wait(time.Minute)
+	}
+	pipeline.Close()
+
+	if err := closeIndex(index); err != nil {
+		return err
+	}
+
+	indexed, ignored := pipeline.Stats.Totals()
+	scan := pipeline.Stats.Scan()
+	fmt.Fprintf(os.Stderr, "indexed %d documents, ignored %d, skipped %d unchanged, in %s\n", indexed, ignored, scan.Skipped, time.Since(start))
+	return nil
+}
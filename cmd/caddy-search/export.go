@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// indexFlags are the flags selecting an index on disk
+type indexFlags struct {
+	datadir *string
+	host    *string
+	name    *string
+	engine  *string
+}
+
+func newIndexFlags(flags *flag.FlagSet) indexFlags {
+	return indexFlags{
+		datadir: flags.String("datadir", "/tmp/caddyIndex", "directory holding the indexes"),
+		host:    flags.String("host", "", "host of the site the index belongs to, with its port unless it's 80 or 443"),
+		name:    flags.String("index", "", "name of the index (see the `index` directive)"),
+		engine:  flags.String("engine", "bleve", "engine of the index"),
+	}
+}
+
+// open opens the selected index. It must not be open by Caddy.
+func (f indexFlags) open() (indexer.Handler, error) {
+	config, err := indexConfig(*f.datadir, *f.host, *f.name)
+	if err != nil {
+		return nil, err
+	}
+	return search.NewIndexer(*f.engine, config)
+}
+
+// closeIndex waits for every piped record to be indexed and closes the index
+func closeIndex(index indexer.Handler) error {
+	for index.Status().Queued > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return index.Close()
+}
+
+// export writes every document of an index as JSON lines
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	idx := newIndexFlags(flags)
+	output := flags.String("o", "", "file to write (default: standard output)")
+	flags.Parse(args)
+
+	index, err := idx.open()
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := indexer.Export(index, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d documents\n", n)
+	return nil
+}
+
+// importDocuments replays documents exported as JSON lines into an index
+func importDocuments(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	idx := newIndexFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("import needs the file to import")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	index, err := idx.open()
+	if err != nil {
+		return err
+	}
+
+	n, err := indexer.Import(index, f)
+	if cerr := closeIndex(index); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d documents\n", n)
+	return nil
+}
@@ -0,0 +1,56 @@
+// Command caddy-search manages the indexes of the search middleware outside
+// of Caddy: it builds indexes offline with the middleware's pipeline, runs
+// queries against them, and handles snapshots and exports.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a subcommand of caddy-search
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"build":    {"build [-datadir dir] (-host host | -index name) -root dir [-include regexp] [-exclude regexp]", build},
+	"query":    {"query [-datadir dir] (-host host | -index name) [-mode m] [-operator op] [-size n] [-format table|json] <text>", query},
+	"snapshot": {"snapshot -url <admin endpoint> -token <token> [-o file]", snapshot},
+	"restore":  {"restore [-datadir dir] (-host host | -index name) <archive>", restore},
+	"export":   {"export [-datadir dir] (-host host | -index name) [-o file]", export},
+	"import":   {"import [-datadir dir] (-host host | -index name) <file>", importDocuments},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "caddy-search:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  caddy-search", commands[name].usage)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// queryResult is a search hit as printed by the query command
+type queryResult struct {
+	Path     string
+	Title    string
+	Score    float64
+	Modified time.Time
+	Indexed  time.Time
+	Body     string
+}
+
+// query runs a query against an index and prints the results
+func query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	idx := newIndexFlags(flags)
+	mode := flags.String("mode", indexer.SimpleMode, "query mode: simple or advanced")
+	operator := flags.String("operator", indexer.OperatorAnd, "default operator of simple queries: and or or")
+	size := flags.Int("size", 10, "number of results")
+	format := flags.String("format", "table", "output format: table or json")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("query needs the text to search")
+	}
+
+	index, err := idx.open()
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	records, err := index.Search(indexer.Query{
+		Text:     strings.Join(flags.Args(), " "),
+		Mode:     *mode,
+		Operator: strings.ToLower(*operator),
+		Size:     *size,
+	})
+	if err != nil {
+		return err
+	}
+
+	results := make([]queryResult, len(records))
+	for i, record := range records {
+		results[i] = queryResult{
+			Path:     record.Path(),
+			Title:    record.Title(),
+			Score:    record.Score(),
+			Modified: record.Modified(),
+			Indexed:  record.Indexed(),
+			Body:     string(record.Body()),
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCORE\tPATH\tTITLE")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%.4f\t%s\t%s\n", result.Score, result.Path, result.Title)
+	}
+	return tw.Flush()
+}
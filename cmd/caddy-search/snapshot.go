@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pedronasser/caddy-search"
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// snapshot downloads a snapshot of a running site's index from its admin
+// endpoint
+func snapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	endpoint := flags.String("url", "", "URL of the site's admin endpoint")
+	token := flags.String("token", "", "admin token")
+	output := flags.String("o", "index.tar.gz", "archive file to write")
+	flags.Parse(args)
+
+	if *endpoint == "" || *token == "" {
+		return errors.New("snapshot needs -url and -token")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(*endpoint, "/")+"/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snapshot failed: %s", resp.Status)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restore extracts a snapshot into the directory of an index. The index must
+// not be open.
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	idx := newIndexFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("restore needs the archive to restore")
+	}
+
+	config, err := indexConfig(*idx.datadir, *idx.host, *idx.name)
+	if err != nil {
+		return err
+	}
+
+	restored, err := search.RestoreIndex(flags.Arg(0), config)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		return errors.New("the index already exists, remove it first")
+	}
+	return nil
+}
+
+// indexConfig returns the config of the index named or of the host's index
+func indexConfig(datadir, host, name string) (indexer.Config, error) {
+	if name == "" && host == "" {
+		return indexer.Config{}, errors.New("either -host or -index is needed")
+	}
+	if name == "" {
+		name = search.NewConfig(search.SiteHost(host), "").HostName
+	}
+	return indexer.Config{HostName: name, IndexDirectory: datadir}, nil
+}
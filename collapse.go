@@ -0,0 +1,64 @@
+package search
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Collapse modes
+const (
+	// CollapseURL groups results sharing the same URL without query string
+	CollapseURL = "url"
+	// CollapseDir groups results under the same directory, up to a depth
+	CollapseDir = "dir"
+)
+
+// Collapse keeps the first (best) result of each group and counts the other
+// results of the group in its Collapsed field. Promoted results are kept as
+// they are.
+func Collapse(results []Result, mode string, depth int) []Result {
+	if mode != CollapseURL && mode != CollapseDir {
+		return results
+	}
+
+	collapsed := make([]Result, 0, len(results))
+	groups := make(map[string]int)
+
+	for _, result := range results {
+		if result.Promoted {
+			collapsed = append(collapsed, result)
+			continue
+		}
+
+		key := result.Host + collapseKey(result.Path, mode, depth)
+		if i, ok := groups[key]; ok {
+			collapsed[i].Collapsed++
+			continue
+		}
+
+		groups[key] = len(collapsed)
+		collapsed = append(collapsed, result)
+	}
+
+	return collapsed
+}
+
+// collapseKey returns the group of the path: its canonical URL (path without
+// query string and fragment) or its first depth directories.
+func collapseKey(p, mode string, depth int) string {
+	if u, err := url.Parse(p); err == nil {
+		p = u.Path
+	}
+	p = path.Clean("/" + p)
+
+	if mode == CollapseURL {
+		return p
+	}
+
+	dirs := strings.Split(strings.Trim(path.Dir(p), "/"), "/")
+	if depth < len(dirs) {
+		dirs = dirs[:depth]
+	}
+	return "/" + strings.Join(dirs, "/")
+}
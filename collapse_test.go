@@ -0,0 +1,36 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollapse(t *testing.T) {
+	results := []search.Result{
+		{Path: "/page?utm=a"},
+		{Path: "/manual/a/one.html"},
+		{Path: "/page?utm=b"},
+		{Path: "/manual/b/two.html"},
+		{Path: "/blog/post.html"},
+	}
+
+	Convey("Given search results", t, func() {
+		Convey("Should collapse by canonical URL", func() {
+			collapsed := search.Collapse(results, search.CollapseURL, 1)
+			So(len(collapsed), ShouldEqual, 4)
+			So(collapsed[0].Collapsed, ShouldEqual, 1)
+		})
+		Convey("Should collapse by directory depth", func() {
+			collapsed := search.Collapse(results, search.CollapseDir, 1)
+			So(len(collapsed), ShouldEqual, 3)
+			So(collapsed[1].Path, ShouldEqual, "/manual/a/one.html")
+			So(collapsed[1].Collapsed, ShouldEqual, 1)
+		})
+		Convey("Should keep deeper directories apart", func() {
+			collapsed := search.Collapse(results, search.CollapseDir, 2)
+			So(len(collapsed), ShouldEqual, 4)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package search
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// LinksField is the record field holding the pages of this site a dynamic
+// page links to, one per line, so that crawls go through the pages they
+// don't fetch again. It's only kept by sites crawling their links.
+const LinksField = "Links"
+
+// crawlLimit bounds the pages visited by a crawl, against endless link
+// spaces like calendars
+var crawlLimit = 10000
+
+// crawlItem is a page waiting to be crawled, depth links away from a seed
+type crawlItem struct {
+	path  string
+	depth int
+}
+
+// CrawlLinks fetches the seed pages through the rest of the handler chain,
+// then the same-origin pages they link to, breadth first, up to
+// Config.CrawlDepth links away from the seeds. Linked pages are only followed
+// if their path can be indexed. Pages whose indexed copy hasn't expired
+// aren't fetched again, the links indexed with them are followed instead.
+// Requests are sent one at a time, waiting Config.CrawlDelay in between.
+func (s *Search) CrawlLinks() {
+	seen := make(map[string]bool)
+	var queue []crawlItem
+	for _, seed := range s.Config.CrawlSeeds {
+		if path, ok := s.Config.ResolvePath("/", seed); ok {
+			queue = append(queue, crawlItem{path: path})
+		}
+	}
+
+	for visited, fetched := 0, 0; len(queue) > 0 && visited < crawlLimit; {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.path] {
+			continue
+		}
+		seen[item.path] = true
+		visited++
+
+		var links []string
+		if s.stale(item.path, time.Time{}) {
+			if fetched > 0 {
+				select {
+				case <-time.After(s.Config.CrawlDelay):
+				case <-s.stop:
+					return
+				}
+			}
+			fetched++
+			links = s.crawlPage(item.path)
+		} else {
+			links = s.indexedLinks(item.path)
+		}
+
+		if item.depth >= s.Config.CrawlDepth {
+			continue
+		}
+		for _, link := range links {
+			if !seen[link] && s.Pipeline.ValidatePath(link) {
+				queue = append(queue, crawlItem{path: link, depth: item.depth + 1})
+			}
+		}
+	}
+}
+
+// crawlPage requests the page to index it and returns the same-origin pages
+// it links to, as the pipeline read them, unless robots directives tell not
+// to follow its links. Unchanged pages give the links indexed with them.
+func (s *Search) crawlPage(path string) []string {
+	r, err := s.internalRequest(path)
+	if err != nil {
+		return nil
+	}
+
+	w := newInternalResponseWriter(false)
+	record, _, _ := s.captureRecord(w, r)
+	links, parsed := s.Pipeline.pipeAndWait(record)
+
+	if w.status != http.StatusOK {
+		return nil
+	}
+	if _, nofollow := robotsHeader(w.header); nofollow {
+		return nil
+	}
+	if !parsed {
+		return s.indexedLinks(path)
+	}
+	return links
+}
+
+// indexedLinks returns the links indexed with the page
+func (s *Search) indexedLinks(path string) []string {
+	record, err := s.Indexer.Get(path)
+	if err != nil {
+		return nil
+	}
+	defer s.Indexer.Kill(record)
+
+	if links := record.Field(LinksField); links != "" {
+		return strings.Split(links, "\n")
+	}
+	return nil
+}
+
+// pageWaiter waits for the pipeline to be done with a crawled page
+type pageWaiter struct {
+	links  []string
+	parsed bool
+	done   chan struct{}
+}
+
+// pipeAndWait pipes the record and waits, up to drainTimeout, until the
+// pipeline is done with it. It returns the pages of this site it links to,
+// and false if it wasn't parsed, like unchanged pages.
+func (p *Pipeline) pipeAndWait(record indexer.Record) ([]string, bool) {
+	w := &pageWaiter{done: make(chan struct{})}
+	p.mutex.Lock()
+	p.waiters[record] = w
+	p.mutex.Unlock()
+
+	forget := func() {
+		p.mutex.Lock()
+		delete(p.waiters, record)
+		p.mutex.Unlock()
+	}
+
+	if !p.send(record) {
+		forget()
+		return nil, false
+	}
+
+	select {
+	case <-w.done:
+		return w.links, w.parsed
+	case <-time.After(drainTimeout):
+		forget()
+		return nil, false
+	}
+}
+
+// noteLinks keeps the pages of this site the page links to, for the crawl
+// waiting for it and, on sites crawling their links, in the index
+func (p *Pipeline) noteLinks(record indexer.Record, hrefs []string) {
+	p.mutex.Lock()
+	w := p.waiters[record]
+	p.mutex.Unlock()
+
+	crawling := len(p.config.CrawlSeeds) > 0
+	if w == nil && !crawling {
+		return
+	}
+
+	var links []string
+	for _, href := range hrefs {
+		if link, ok := p.config.ResolvePath(record.Path(), href); ok {
+			links = append(links, link)
+		}
+	}
+
+	if w != nil {
+		w.links, w.parsed = links, true
+	}
+	if crawling {
+		record.SetField(LinksField, strings.Join(links, "\n"))
+	}
+}
+
+// release tells the crawl waiting for the page that the pipeline is done
+// with it
+func (p *Pipeline) release(record indexer.Record) {
+	p.mutex.Lock()
+	w, ok := p.waiters[record]
+	delete(p.waiters, record)
+	p.mutex.Unlock()
+
+	if ok {
+		close(w.done)
+	}
+}
+
+// repeat runs fn, then again every interval until the search stops. A zero
+// interval only runs it once.
+func (s *Search) repeat(interval time.Duration, fn func()) {
+	fn()
+	if interval <= 0 {
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			fn()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package search_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCrawlLinks(t *testing.T) {
+	Convey("Given a site crawling its links", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		linked := &fakeSite{pages: map[string]string{
+			"/": `<title>Home</title>
+				<a href="/a">A</a>
+				<a href="http://other.com/x">Other</a>
+				<a href="/private/p">Private</a>
+				<a href="/n" rel="nofollow">Not followed</a>`,
+			"/a":         `<title>A</title><a href="/b">B</a>`,
+			"/b":         `<title>B</title><a href="/c">C</a>`,
+			"/c":         `<title>C</title><p>Too deep</p>`,
+			"/n":         `<title>N</title>`,
+			"/private/p": `<title>P</title>`,
+		}}
+		site.search.Next = linked
+		site.config.CrawlSeeds = []string{"/"}
+		site.config.CrawlDelay = 0
+		site.config.CrawlDepth = 2
+		site.config.ExcludePaths = search.ConvertToRegExp([]string{"^/private"})
+		site.config.Expire = 0
+
+		Convey("Should only fetch the pages in scope, up to the crawl depth", func() {
+			site.search.CrawlLinks()
+			So(linked.paths(), ShouldResemble, []string{"/", "/a", "/b"})
+			site.settle()
+			So(site.indexed("/"), ShouldBeTrue)
+			So(site.indexed("/b"), ShouldBeTrue)
+			So(site.indexed("/c"), ShouldBeFalse)
+		})
+
+		Convey("Should follow the indexed links of the pages it doesn't fetch again", func() {
+			site.search.CrawlLinks()
+			site.settle()
+
+			site.config.Expire = time.Hour
+			site.config.CrawlDepth = 3
+			site.search.CrawlLinks()
+			So(linked.paths(), ShouldResemble, []string{"/", "/a", "/b", "/c"})
+		})
+
+		Convey("Should index the pages it fetches for everyone", func() {
+			site.search.CrawlLinks()
+			site.settle()
+
+			record, err := site.index.Get("/a")
+			So(err, ShouldBeNil)
+			defer site.index.Kill(record)
+			So(record.Field(search.AccessField), ShouldBeEmpty)
+		})
+	})
+}
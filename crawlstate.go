@@ -0,0 +1,215 @@
+package search
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HashField is the record field holding the hash of the document's content
+const HashField = "Hash"
+
+// ETagField is the record field holding the ETag of a dynamic page
+const ETagField = "ETag"
+
+// contentHash returns the hash stored in HashField for the content
+func contentHash(content []byte) string {
+	sum := sha1.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileState is what was known of a static file when it was last read
+type FileState struct {
+	Modified time.Time
+	Size     int64
+	Hash     string
+}
+
+// CrawlState keeps the state of the static files already indexed, so that
+// scans skip the files that haven't changed. It is persisted as JSON.
+type CrawlState struct {
+	file        string
+	fingerprint string
+	mutex       sync.Mutex
+	files       map[string]FileState
+	pending     map[string]FileState
+	dirty       bool
+}
+
+// crawlStateData is the content of a crawl state file
+type crawlStateData struct {
+	Fingerprint string
+	Files       map[string]FileState
+}
+
+// LoadCrawlState loads the crawl state kept in the file. A missing or
+// invalid file, or one written with another fingerprint, gives an empty
+// state.
+func LoadCrawlState(file, fingerprint string) *CrawlState {
+	c := &CrawlState{
+		file:        file,
+		fingerprint: fingerprint,
+		files:       make(map[string]FileState),
+		pending:     make(map[string]FileState),
+	}
+	if data, err := ioutil.ReadFile(file); err == nil {
+		var saved crawlStateData
+		if json.Unmarshal(data, &saved) == nil && saved.Fingerprint == fingerprint && saved.Files != nil {
+			c.files = saved.Files
+		}
+	}
+	return c
+}
+
+// CrawlStateFile returns the crawl state file of the site inside its index
+// directory
+func (c *Config) CrawlStateFile() string {
+	return filepath.Join(indexPath(c.indexConfig()), "crawl-"+IndexName(c.Host)+".json")
+}
+
+// crawlStateVersion changes when the way files are indexed changes, to drop
+// the crawl states written before
+const crawlStateVersion = 1
+
+// Fingerprint hashes what decides how static files are indexed: the paths
+// included and excluded, the robots rules, the visibility rules, the
+// duplicate detection and the MIME types extractors are registered for.
+// Crawl states saved with another fingerprint are dropped, so that every file
+// is indexed again.
+func (c *Config) Fingerprint() string {
+	h := sha1.New()
+	fmt.Fprintln(h, "version", crawlStateVersion)
+	for _, re := range c.IncludePaths {
+		fmt.Fprintln(h, "+path", re)
+	}
+	for _, re := range c.ExcludePaths {
+		fmt.Fprintln(h, "-path", re)
+	}
+	if c.Robots != nil {
+		for _, rule := range c.Robots.rules {
+			fmt.Fprintln(h, "robots", rule.allow, rule.path)
+		}
+	}
+	for _, rule := range c.AccessRules {
+		fmt.Fprintln(h, "visibility", rule.Path, strings.Join(rule.Users, ","))
+	}
+	fmt.Fprintln(h, "duplicates", c.Duplicates, c.DuplicateDistance)
+	for _, mimeType := range extractorTypes() {
+		fmt.Fprintln(h, "extractor", mimeType)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Changed returns true if the file is unknown or its modification time or
+// size changed since it was last read
+func (c *CrawlState) Changed(path string, info os.FileInfo) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.files[path]
+	return !ok || !state.Modified.Equal(info.ModTime()) || state.Size != info.Size()
+}
+
+// Read returns true if the content of the file just read changed since it
+// was indexed. The new state is then kept aside until Commit, once the file
+// is indexed, otherwise it's kept right away.
+func (c *CrawlState) Read(path string, state FileState) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if old, ok := c.files[path]; ok && old.Hash == state.Hash {
+		c.files[path] = state
+		c.dirty = true
+		return false
+	}
+	c.pending[path] = state
+	return true
+}
+
+// Commit keeps the state of the file read last, once it's indexed
+func (c *CrawlState) Commit(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if state, ok := c.pending[path]; ok {
+		delete(c.pending, path)
+		c.files[path] = state
+		c.dirty = true
+	}
+}
+
+// Discard drops the state of the file read last: it failed to be indexed
+// and will be read again by the next scan
+func (c *CrawlState) Discard(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pending, path)
+}
+
+// Remove forgets the file
+func (c *CrawlState) Remove(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pending, path)
+	if _, ok := c.files[path]; ok {
+		delete(c.files, path)
+		c.dirty = true
+	}
+}
+
+// Paths returns the paths of the files known
+func (c *CrawlState) Paths() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	paths := make([]string, 0, len(c.files))
+	for path := range c.files {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Reset forgets every file, so that the next scan reads them all again
+func (c *CrawlState) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.files = make(map[string]FileState)
+	c.pending = make(map[string]FileState)
+	c.dirty = true
+}
+
+// Save writes the state to its file if it changed
+func (c *CrawlState) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(crawlStateData{Fingerprint: c.fingerprint, Files: c.files})
+	if err != nil {
+		return err
+	}
+
+	tmp := c.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
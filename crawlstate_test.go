@@ -0,0 +1,86 @@
+package search_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCrawlState(t *testing.T) {
+	Convey("Given a crawl state file", t, func() {
+		dir, _ := ioutil.TempDir("", "crawl")
+		defer os.RemoveAll(dir)
+
+		page := filepath.Join(dir, "page.html")
+		ioutil.WriteFile(page, []byte("<title>Page</title>"), 0644)
+		info, _ := os.Stat(page)
+
+		file := filepath.Join(dir, "crawl.json")
+		state := search.LoadCrawlState(file, "v1")
+
+		Convey("Should see unknown files as changed", func() {
+			So(state.Changed("/page.html", info), ShouldBeTrue)
+		})
+
+		Convey("Should only keep the files once they are indexed", func() {
+			read := search.FileState{Modified: info.ModTime(), Size: info.Size(), Hash: "abc"}
+			So(state.Read("/page.html", read), ShouldBeTrue)
+			So(state.Changed("/page.html", info), ShouldBeTrue)
+			So(state.Paths(), ShouldBeEmpty)
+
+			state.Discard("/page.html")
+			state.Commit("/page.html")
+			So(state.Paths(), ShouldBeEmpty)
+		})
+
+		Convey("Should remember indexed files across restarts", func() {
+			So(state.Read("/page.html", search.FileState{
+				Modified: info.ModTime(),
+				Size:     info.Size(),
+				Hash:     "abc",
+			}), ShouldBeTrue)
+			state.Commit("/page.html")
+			So(state.Save(), ShouldBeNil)
+
+			reloaded := search.LoadCrawlState(file, "v1")
+			So(reloaded.Changed("/page.html", info), ShouldBeFalse)
+
+			Convey("Should only report content changes", func() {
+				touched := search.FileState{Modified: info.ModTime().Add(time.Second), Size: info.Size(), Hash: "abc"}
+				So(reloaded.Read("/page.html", touched), ShouldBeFalse)
+				touched.Hash = "def"
+				So(reloaded.Read("/page.html", touched), ShouldBeTrue)
+			})
+
+			Convey("Should list and forget the known files", func() {
+				So(reloaded.Paths(), ShouldResemble, []string{"/page.html"})
+				reloaded.Remove("/page.html")
+				So(reloaded.Paths(), ShouldBeEmpty)
+				So(reloaded.Changed("/page.html", info), ShouldBeTrue)
+			})
+
+			Convey("Should drop a state saved with another fingerprint", func() {
+				So(search.LoadCrawlState(file, "v2").Paths(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	Convey("Given the config of a site", t, func() {
+		config := search.NewConfig("example.com", "")
+
+		Convey("Should change with what decides how files are indexed", func() {
+			before := config.Fingerprint()
+			So(config.Fingerprint(), ShouldEqual, before)
+
+			config.ExcludePaths = search.ConvertToRegExp([]string{"^/drafts/"})
+			So(config.Fingerprint(), ShouldNotEqual, before)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package search
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Duplicate handling modes
+const (
+	// DuplicatesSkip drops near-duplicates before they are indexed
+	DuplicatesSkip = "skip"
+	// DuplicatesCluster indexes near-duplicates but hides them from results
+	DuplicatesCluster = "cluster"
+)
+
+// Record fields used for near-duplicate detection
+const (
+	FingerprintField = "Fingerprint"
+	DuplicateOfField = "DuplicateOf"
+)
+
+// shingleSize is the number of words in each shingle
+const shingleSize = 3
+
+var textPolicy = bluemonday.StrictPolicy()
+
+// SimHash computes a 64 bit similarity fingerprint of a document body. Tags
+// are stripped and the text is split into overlapping word shingles; similar
+// texts get fingerprints that differ in only a few bits.
+func SimHash(body []byte) uint64 {
+	words := bytes.FieldsFunc(bytes.ToLower(textPolicy.SanitizeBytes(body)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	shingles := len(words) - shingleSize + 1
+	if shingles < 1 && len(words) > 0 {
+		shingles = 1
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	for i := 0; i < shingles; i++ {
+		h.Reset()
+		end := i + shingleSize
+		if end > len(words) {
+			end = len(words)
+		}
+		for _, w := range words[i:end] {
+			h.Write(w)
+			h.Write([]byte{' '})
+		}
+		sum := h.Sum64()
+		for bit := uint(0); bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fp uint64
+	for bit := uint(0); bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fp |= 1 << bit
+		}
+	}
+	return fp
+}
+
+// Distance returns the number of bits that differ between two fingerprints
+func Distance(a, b uint64) (n int) {
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return
+}
+
+// formatFingerprint and parseFingerprint convert fingerprints to and from
+// their stored form
+func formatFingerprint(fp uint64) string {
+	return strconv.FormatUint(fp, 16)
+}
+
+func parseFingerprint(s string) (uint64, bool) {
+	fp, err := strconv.ParseUint(s, 16, 64)
+	return fp, err == nil
+}
+
+// Cluster is a document and the near-duplicates found of it
+type Cluster struct {
+	Path       string
+	Duplicates []string
+}
+
+// Duplicates keeps the fingerprints of indexed documents to find
+// near-duplicates. The first document seen is the original of a cluster.
+type Duplicates struct {
+	distance     int
+	mutex        sync.RWMutex
+	fingerprints map[string]uint64
+	originals    map[string]string
+}
+
+// NewDuplicates creates a Duplicates finding fingerprints at most distance
+// bits apart
+func NewDuplicates(distance int) *Duplicates {
+	return &Duplicates{
+		distance:     distance,
+		fingerprints: make(map[string]uint64),
+		originals:    make(map[string]string),
+	}
+}
+
+// Add registers the fingerprint of the path and returns the path it is a
+// near-duplicate of, or an empty string
+func (d *Duplicates) Add(path string, fp uint64) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.originals, path)
+	d.fingerprints[path] = fp
+
+	for other, ofp := range d.fingerprints {
+		if other == path || d.originals[other] != "" {
+			continue
+		}
+		if Distance(fp, ofp) <= d.distance {
+			d.originals[path] = other
+			return other
+		}
+	}
+
+	return ""
+}
+
+// Remove forgets the path
+func (d *Duplicates) Remove(path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.fingerprints, path)
+	delete(d.originals, path)
+	for dup, original := range d.originals {
+		if original == path {
+			delete(d.originals, dup)
+		}
+	}
+}
+
+// Clusters returns the known clusters of near-duplicates, sorted by path
+func (d *Duplicates) Clusters() []Cluster {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	byOriginal := make(map[string][]string)
+	for dup, original := range d.originals {
+		byOriginal[original] = append(byOriginal[original], dup)
+	}
+
+	clusters := make([]Cluster, 0, len(byOriginal))
+	for original, dups := range byOriginal {
+		sort.Strings(dups)
+		clusters = append(clusters, Cluster{Path: original, Duplicates: dups})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Path < clusters[j].Path
+	})
+
+	return clusters
+}
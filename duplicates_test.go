@@ -0,0 +1,69 @@
+package search_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const article = `<html><body><h1>Installing the server</h1>
+<p>Download the latest release for your platform, extract the archive and
+move the binary somewhere in your PATH. Then write a configuration file
+describing your sites and start the server from the directory holding it.</p>
+</body></html>`
+
+func TestDuplicates(t *testing.T) {
+	Convey("Given near-duplicate documents", t, func() {
+		original := search.SimHash([]byte(article))
+		printable := search.SimHash([]byte(`<div class="print">` + article + `<p>Printed copy</p></div>`))
+		other := search.SimHash([]byte(`<p>Release notes: fixed a crash when reloading the configuration twice in a row.</p>`))
+
+		Convey("Should give them close fingerprints", func() {
+			So(search.Distance(original, printable), ShouldBeLessThan, search.Distance(original, other))
+		})
+
+		Convey("Should cluster them under the first path", func() {
+			d := search.NewDuplicates(search.Distance(original, printable))
+			So(d.Add("/install.html", original), ShouldEqual, "")
+			So(d.Add("/install.html?print=1", printable), ShouldEqual, "/install.html")
+
+			clusters := d.Clusters()
+			So(len(clusters), ShouldEqual, 1)
+			So(clusters[0].Path, ShouldEqual, "/install.html")
+			So(clusters[0].Duplicates, ShouldResemble, []string{"/install.html?print=1"})
+
+			d.Remove("/install.html")
+			So(d.Clusters(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestSkipDuplicates(t *testing.T) {
+	Convey("Given a site skipping duplicate files", t, func() {
+		site := newTestSite(map[string]string{
+			"a.html": article,
+			"b.html": article,
+		})
+		Reset(site.close)
+
+		site.config.Duplicates = search.DuplicatesSkip
+		scan := func() {
+			search.ScanToPipe(site.root, site.pipeline, site.index)
+			site.settle()
+		}
+		scan()
+		So(site.indexed("/a.html"), ShouldBeTrue)
+		So(site.indexed("/b.html"), ShouldBeFalse)
+
+		Convey("Should index the duplicate once its original is gone", func() {
+			os.Remove(filepath.Join(site.root, "a.html"))
+			scan()
+			scan()
+			So(site.indexed("/a.html"), ShouldBeFalse)
+			So(site.indexed("/b.html"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,19 @@
+package search
+
+// Hooks into the package for the tests of search_test
+var (
+	RegisterSite   = registerSite
+	UnregisterSite = unregisterSite
+	ClaimScan      = claimScan
+	ReleaseScan    = releaseScan
+
+	RobotsDirectives = robotsDirectives
+	LookupExtractor  = lookupExtractor
+)
+
+// UnregisterExtractor removes the extractor registered for the MIME type
+func UnregisterExtractor(mimeType string) {
+	extractors.Lock()
+	delete(extractors.m, mimeType)
+	extractors.Unlock()
+}
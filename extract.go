@@ -0,0 +1,100 @@
+package search
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// Content is a document handed to an Extractor. Body must not be modified.
+type Content struct {
+	Path     string
+	MimeType string
+	Body     []byte
+	Fields   map[string]string
+}
+
+// Extracted is what an Extractor read from a document. Body is the text to
+// index, a nil Body keeps the document's content. Fields are stored with the
+// document.
+type Extracted struct {
+	Title  string
+	Body   []byte
+	Fields map[string]string
+}
+
+// Extractor reads the title and the text of documents of a format. Documents
+// without a title are titled by their file name.
+type Extractor interface {
+	Extract(Content) (*Extracted, error)
+}
+
+// ExtractorFunc is a func used as an Extractor
+type ExtractorFunc func(Content) (*Extracted, error)
+
+// Extract calls f
+func (f ExtractorFunc) Extract(c Content) (*Extracted, error) {
+	return f(c)
+}
+
+var extractors = struct {
+	sync.RWMutex
+	m map[string]Extractor
+}{m: make(map[string]Extractor)}
+
+// RegisterExtractor makes the extractor parse the documents of the MIME type,
+// replacing the extractor registered before for it. Documents of types
+// without an extractor are not indexed.
+func RegisterExtractor(mimeType string, e Extractor) {
+	extractors.Lock()
+	extractors.m[mimeType] = e
+	extractors.Unlock()
+}
+
+// extractorTypes returns the sorted MIME types extractors are registered for
+func extractorTypes() []string {
+	extractors.RLock()
+	defer extractors.RUnlock()
+	types := make([]string, 0, len(extractors.m))
+	for mimeType := range extractors.m {
+		types = append(types, mimeType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// lookupExtractor returns the extractor registered for the MIME type
+func lookupExtractor(mimeType string) (Extractor, bool) {
+	extractors.RLock()
+	defer extractors.RUnlock()
+	e, ok := extractors.m[mimeType]
+	return e, ok
+}
+
+func init() {
+	RegisterExtractor("text/html", ExtractorFunc(extractHTML))
+	RegisterExtractor("application/xhtml+xml", ExtractorFunc(extractHTML))
+	RegisterExtractor("text/plain", ExtractorFunc(extractText))
+	RegisterExtractor("text/markdown", ExtractorFunc(extractText))
+}
+
+// extractHTML reads the title of an HTML document, or its first heading if it
+// has none, and strips its markup
+func extractHTML(c Content) (*Extracted, error) {
+	content, err := getHTMLContent(bytes.NewReader(c.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	title := content.Title
+	if title == "" {
+		title = content.Heading
+	}
+
+	return &Extracted{Title: title, Body: bm.SanitizeBytes(c.Body)}, nil
+}
+
+// extractText indexes plain text as it is
+func extractText(c Content) (*Extracted, error) {
+	return &Extracted{}, nil
+}
@@ -0,0 +1,113 @@
+package search_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// titled returns an extractor giving every document the title
+func titled(title string) search.Extractor {
+	return search.ExtractorFunc(func(c search.Content) (*search.Extracted, error) {
+		return &search.Extracted{
+			Title:  title,
+			Body:   []byte("Extracted text"),
+			Fields: map[string]string{"Format": c.MimeType},
+		}, nil
+	})
+}
+
+func TestExtractors(t *testing.T) {
+	Convey("Given dynamic pages of custom types", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		types := map[string]string{
+			"/registered": "application/x-registered",
+			"/overridden": "application/x-overridden",
+			"/unknown":    "application/x-unknown",
+			"/copy":       "text/html",
+			"/robots":     "text/html",
+		}
+		bodies := map[string]string{
+			"/copy":   `<link rel="canonical" href="/original"><p>Copy</p>`,
+			"/robots": `<meta name="robots" content="noindex"><p>Hidden</p>`,
+		}
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			body, ok := bodies[r.URL.Path]
+			if !ok {
+				body = "Document content"
+			}
+			w.Header().Set("Content-Type", types[r.URL.Path])
+			w.Write([]byte(body))
+			return http.StatusOK, nil
+		})
+
+		builtin, _ := search.LookupExtractor("text/html")
+		Reset(func() {
+			search.UnregisterExtractor("application/x-registered")
+			search.UnregisterExtractor("application/x-overridden")
+			search.RegisterExtractor("text/html", builtin)
+		})
+
+		serve := func(path string) {
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+		indexed := func(path string) (title, format string) {
+			eventually(func() bool { return site.indexed(path) })
+			record, err := site.index.Get(path)
+			if err != nil {
+				return "", ""
+			}
+			defer site.index.Kill(record)
+			return record.Title(), record.Field("Format")
+		}
+
+		Convey("Should read them with the extractor registered for their type", func() {
+			search.RegisterExtractor("application/x-registered", titled("Registered"))
+			serve("/registered")
+
+			title, format := indexed("/registered")
+			So(title, ShouldEqual, "Registered")
+			So(format, ShouldEqual, "application/x-registered")
+		})
+
+		Convey("Should use the last extractor registered for a type", func() {
+			search.RegisterExtractor("application/x-overridden", titled("First"))
+			search.RegisterExtractor("application/x-overridden", titled("Second"))
+			serve("/overridden")
+
+			title, _ := indexed("/overridden")
+			So(title, ShouldEqual, "Second")
+		})
+
+		Convey("Should still follow the robots meta tags and canonical links of HTML documents", func() {
+			search.RegisterExtractor("text/html", titled("Custom"))
+			serve("/copy")
+
+			title, _ := indexed("/original")
+			So(title, ShouldEqual, "Custom")
+			So(site.indexed("/copy"), ShouldBeFalse)
+
+			serve("/robots")
+			So(eventually(func() bool {
+				_, ignored := site.pipeline.Stats.Totals()
+				return ignored > 0
+			}), ShouldBeTrue)
+			So(site.indexed("/robots"), ShouldBeFalse)
+		})
+
+		Convey("Should not index types without an extractor", func() {
+			serve("/unknown")
+			So(eventually(func() bool {
+				_, ignored := site.pipeline.Stats.Totals()
+				return ignored > 0
+			}), ShouldBeTrue)
+			So(site.indexed("/unknown"), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package search
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// sites holds the search middlewares of this process by site host, so that
+// a site can query the indexes of the others
+var sites = struct {
+	sync.RWMutex
+	m map[string]*Search
+}{m: make(map[string]*Search)}
+
+// registerSite makes the middleware available to federated searches
+func registerSite(host string, s *Search) {
+	sites.Lock()
+	sites.m[host] = s
+	sites.Unlock()
+}
+
+// unregisterSite removes the middleware if it is still the one registered
+func unregisterSite(host string, s *Search) {
+	sites.Lock()
+	if sites.m[host] == s {
+		delete(sites.m, host)
+	}
+	sites.Unlock()
+}
+
+// lookupSite returns the middleware registered for the host, or nil
+func lookupSite(host string) *Search {
+	sites.RLock()
+	defer sites.RUnlock()
+	return sites.m[host]
+}
+
+// siteHost returns the host (and non-default port) of a site's address
+func siteHost(cnf *httpserver.SiteConfig) string {
+	return joinSiteHost(cnf.Host(), cnf.Addr.Port)
+}
+
+// SiteHost returns the host (and non-default port) of a host:port address
+// the way sites are identified, e.g. for federation and by their index
+func SiteHost(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return joinSiteHost(host, port)
+}
+
+func joinSiteHost(host, port string) string {
+	switch port {
+	case "", "80", "443", "http", "https":
+		return host
+	default:
+		return net.JoinHostPort(host, port)
+	}
+}
+
+// hit is a record found in the index of a site
+type hit struct {
+	indexer.Record
+	host  string
+	score float64
+}
+
+// searchSites runs the query on this site and on its federated sites. Scores
+// are normalized per site (the best hit of each site scores 1) and the hits
+// are merged by normalized score. Federated sites that are unknown or fail
+// are left out.
+func (s *Search) searchSites(q indexer.Query) ([]hit, error) {
+	records, err := s.Indexer.Search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Config.Federate) == 0 {
+		hits := make([]hit, len(records))
+		for i, record := range records {
+			hits[i] = hit{Record: record, score: record.Score()}
+		}
+		return hits, nil
+	}
+
+	hits := normalize(records, s.Config.Host)
+
+	for _, host := range s.Config.Federate {
+		site := lookupSite(host)
+		if site == nil || site == s {
+			continue
+		}
+		records, err := site.Indexer.Search(q)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, normalize(records, host)...)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].score > hits[j].score
+	})
+
+	if len(hits) > q.Size && q.Size > 0 {
+		hits = hits[:q.Size]
+	}
+
+	return hits, nil
+}
+
+// normalize labels the records with their host and divides their scores by
+// the best score
+func normalize(records []indexer.Record, host string) []hit {
+	max := 0.0
+	for _, record := range records {
+		if record.Score() > max {
+			max = record.Score()
+		}
+	}
+
+	hits := make([]hit, len(records))
+	for i, record := range records {
+		score := 0.0
+		if max > 0 {
+			score = record.Score() / max
+		}
+		hits[i] = hit{Record: record, host: host, score: score}
+	}
+	return hits
+}
@@ -0,0 +1,92 @@
+package search_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	"github.com/pedronasser/caddy-search/indexer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// scoredIndex answers every query with the paths and scores it holds
+type scoredIndex struct {
+	indexer.Handler
+	scores map[string]float64
+}
+
+func (s scoredIndex) Search(indexer.Query) ([]indexer.Record, error) {
+	var records []indexer.Record
+	for path, score := range s.scores {
+		record := s.Handler.Record(path)
+		record.SetScore(score)
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Score() > records[j].Score()
+	})
+	return records, nil
+}
+
+func TestFederate(t *testing.T) {
+	Convey("Given a site federating the search of other sites", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		site.config.Federate = []string{"docs.example.com", "one.example.com", "zero.example.com", "unknown.example.com"}
+		site.search.Indexer = scoredIndex{site.index, map[string]float64{"/a1": 4, "/a2": 2}}
+
+		federated := map[string]map[string]float64{
+			"docs.example.com": {"/b1": 10, "/b2": 5},
+			"one.example.com":  {"/c1": 0.3},
+			"zero.example.com": {"/d1": 0},
+		}
+		for host, scores := range federated {
+			other := &search.Search{
+				Config:  &search.Config{Host: host},
+				Indexer: scoredIndex{site.index, scores},
+			}
+			search.RegisterSite(host, other)
+			Reset(func() { search.UnregisterSite(other.Config.Host, other) })
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/search?q=page", nil)
+		w := httptest.NewRecorder()
+		status, err := site.search.SearchJSON(w, r)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+
+		var results []search.Result
+		So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+
+		Convey("Should merge the hits by score normalized per site", func() {
+			var paths []string
+			scores := make(map[string]float64)
+			for _, result := range results {
+				paths = append(paths, result.Path)
+				scores[result.Path] = result.Score
+			}
+			So(paths, ShouldResemble, []string{"/a1", "/b1", "/c1", "/a2", "/b2", "/d1"})
+			So(scores["/a1"], ShouldEqual, 1)
+			So(scores["/b2"], ShouldEqual, 0.5)
+			So(scores["/c1"], ShouldEqual, 1)
+			So(scores["/d1"], ShouldEqual, 0)
+		})
+
+		Convey("Should label the hits with their site", func() {
+			for _, result := range results {
+				switch result.Path {
+				case "/a1":
+					So(result.Host, ShouldEqual, "example.com")
+					So(result.URL, ShouldEqual, "/a1")
+				case "/b1":
+					So(result.Host, ShouldEqual, "docs.example.com")
+					So(result.URL, ShouldEqual, "//docs.example.com/b1")
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,53 @@
+package indexer_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedronasser/caddy-search/indexer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchive(t *testing.T) {
+	Convey("Given an index directory", t, func() {
+		src, _ := ioutil.TempDir("", "index")
+		dst, _ := ioutil.TempDir("", "restored")
+		defer os.RemoveAll(src)
+		defer os.RemoveAll(dst)
+
+		os.MkdirAll(filepath.Join(src, "store"), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(src, "index_meta.json"), []byte(`{"storage":"boltdb"}`), 0644)
+		ioutil.WriteFile(filepath.Join(src, "store", "root.bolt"), []byte("data"), 0644)
+
+		Convey("Should restore the same files from its archive", func() {
+			var buf bytes.Buffer
+			So(indexer.WriteArchive(&buf, src, nil), ShouldBeNil)
+			So(indexer.ExtractArchive(&buf, dst), ShouldBeNil)
+
+			meta, err := ioutil.ReadFile(filepath.Join(dst, "index_meta.json"))
+			So(err, ShouldBeNil)
+			So(string(meta), ShouldEqual, `{"storage":"boltdb"}`)
+
+			data, err := ioutil.ReadFile(filepath.Join(dst, "store", "root.bolt"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "data")
+		})
+
+		Convey("Should leave out the entries it doesn't include", func() {
+			ioutil.WriteFile(filepath.Join(src, "crawl.json"), []byte("{}"), 0644)
+
+			var buf bytes.Buffer
+			include := func(name string) bool { return name != "crawl.json" }
+			So(indexer.WriteArchive(&buf, src, include), ShouldBeNil)
+			So(indexer.ExtractArchive(&buf, dst), ShouldBeNil)
+
+			_, err := os.Stat(filepath.Join(dst, "crawl.json"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = os.Stat(filepath.Join(dst, "store", "root.bolt"))
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -27,7 +27,7 @@ func New(name string) (*bleveIndexer, error) {
 		return nil, err
 	}
 
-	indxr := &bleveIndexer{}
+	indxr := &bleveIndexer{name: name}
 
 	pipe, err := piper.New(
 		piper.P(1, indxr.index),
@@ -39,8 +39,9 @@ func New(name string) (*bleveIndexer, error) {
 
 	indxr.pipeline = pipe
 	indxr.bleve = blv
+	indxr.done = make(chan struct{})
 
-	go consumeOutput(pipe)
+	go consumeOutput(pipe, indxr.done)
 
 	return indxr, nil
 }
@@ -68,13 +69,16 @@ func openIndex(name string) (bleve.Index, error) {
 	return blv, nil
 }
 
-func consumeOutput(pipe piper.Handler) {
+func consumeOutput(pipe piper.Handler, done chan struct{}) {
 	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
 	out := pipe.Output()
 	for {
 		select {
 		case <-out:
 		case <-tick.C:
+		case <-done:
+			return
 		}
 	}
 }
@@ -1,18 +1,38 @@
 package bleve
 
 import (
-	"fmt"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/pedronasser/caddy-search/indexer"
 	"github.com/pedronasser/go-piper"
 )
 
 type bleveIndexer struct {
+	name     string
 	pipeline piper.Handler
 	bleve    bleve.Index
+
+	// writes is held for reading by index writes and for writing by
+	// snapshots, so that snapshots see no write in progress
+	writes sync.RWMutex
+
+	mutex         sync.Mutex
+	closed        bool
+	done          chan struct{}
+	queued        int64
+	failed        int64
+	lastError     string
+	lastErrorTime time.Time
 }
 
 // Bleve's record data struct
@@ -22,6 +42,7 @@ type indexRecord struct {
 	Body     string
 	Modified string
 	Indexed  string
+	Fields   map[string]string
 }
 
 // Record method get existent or creates a new Record to be saved/updated in the indexer
@@ -31,11 +52,14 @@ func (i *bleveIndexer) Record(path string) indexer.Record {
 	record.fullPath = ""
 	record.title = ""
 	record.document = make(map[string]interface{})
+	record.fields = make(map[string]string)
 	record.ignored = false
 	record.loaded = false
 	record.body = bufPool.Get().([]byte)
 	record.indexed = time.Time{}
 	record.modified = time.Time{}
+	record.score = 0
+	record.done = nil
 	record.indexer = i
 	return record
 }
@@ -46,13 +70,30 @@ func (i *bleveIndexer) Kill(r indexer.Record) {
 }
 
 // Search method lookup for records using a query
-func (i *bleveIndexer) Search(q string) (records []indexer.Record) {
-	query := bleve.NewQueryStringQuery(q)
-	request := bleve.NewSearchRequest(query)
+func (i *bleveIndexer) Search(q indexer.Query) (records []indexer.Record, err error) {
+	var bq query.Query
+
+	if q.Mode == indexer.AdvancedMode {
+		qs := bleve.NewQueryStringQuery(q.Text)
+		if _, err = qs.Parse(); err != nil {
+			return nil, &indexer.QueryError{Query: q.Text, Err: err}
+		}
+		bq = qs
+	} else {
+		bq = simpleQuery(indexer.ParseSimple(q.Text), q.Operator)
+		if bq == nil {
+			return nil, nil
+		}
+	}
+
+	request := bleve.NewSearchRequest(bq)
+	if q.Size > 0 {
+		request.Size = q.Size
+	}
 	request.Highlight = bleve.NewHighlight()
 	result, err := i.bleve.Search(request)
-	if err != nil { // an empty query would cause this
-		return
+	if err != nil {
+		return nil, err
 	}
 
 	for _, match := range result.Hits {
@@ -63,6 +104,8 @@ func (i *bleveIndexer) Search(q string) (records []indexer.Record) {
 			continue
 		}
 
+		rec.SetScore(match.Score)
+
 		if len(match.Fragments["Body"]) > 0 {
 			rec.SetBody([]byte(match.Fragments["Body"][0]))
 		}
@@ -73,18 +116,202 @@ func (i *bleveIndexer) Search(q string) (records []indexer.Record) {
 	return
 }
 
-// Pipe sends the new record to the pipeline
+// simpleQuery builds the bleve query for a plain text query. Terms and
+// phrases are joined by the operator, exclusions must not match.
+func simpleQuery(sq indexer.SimpleQuery, operator string) query.Query {
+	if sq.Empty() {
+		return nil
+	}
+
+	var parts []query.Query
+	for _, term := range sq.Terms {
+		parts = append(parts, bleve.NewMatchQuery(term))
+	}
+	for _, phrase := range sq.Phrases {
+		parts = append(parts, bleve.NewMatchPhraseQuery(phrase))
+	}
+
+	var match query.Query
+	if operator == indexer.OperatorOr {
+		match = bleve.NewDisjunctionQuery(parts...)
+	} else {
+		match = bleve.NewConjunctionQuery(parts...)
+	}
+
+	if len(sq.Excluded) == 0 {
+		return match
+	}
+
+	var excluded []query.Query
+	for _, ex := range sq.Excluded {
+		excluded = append(excluded, bleve.NewMatchPhraseQuery(ex))
+	}
+
+	return bleve.NewBooleanQuery([]query.Query{match}, nil, excluded)
+}
+
+// Pipe sends the new record to the pipeline. Records piped after Close are
+// dropped.
 func (i *bleveIndexer) Pipe(r indexer.Record) {
+	i.mutex.Lock()
+	if i.closed {
+		i.mutex.Unlock()
+		if rec, ok := r.(*Record); ok && rec.done != nil {
+			rec.done(errClosed)
+		}
+		i.Kill(r)
+		return
+	}
+	i.queued++
+	i.mutex.Unlock()
+
 	i.pipeline.Input() <- r
 }
 
+func (i *bleveIndexer) queue(n int64) {
+	i.mutex.Lock()
+	i.queued += n
+	i.mutex.Unlock()
+}
+
+func (i *bleveIndexer) pending() int64 {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.queued
+}
+
+// Status returns the queue length, the size on disk and the last error
+func (i *bleveIndexer) Status() indexer.Status {
+	var size int64
+	filepath.Walk(i.name, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return indexer.Status{
+		Queued:        i.queued,
+		Failed:        i.failed,
+		Size:          size,
+		LastError:     i.lastError,
+		LastErrorTime: i.lastErrorTime,
+	}
+}
+
+// Get returns the stored record of the path
+func (i *bleveIndexer) Get(path string) (indexer.Record, error) {
+	rec := i.Record(path)
+	if !rec.Load() {
+		i.Kill(rec)
+		return nil, indexer.ErrNotFound
+	}
+	return rec, nil
+}
+
+// List returns stored records sorted by path
+func (i *bleveIndexer) List(offset, limit int) (records []indexer.Record, err error) {
+	request := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), limit, offset, false)
+	request.SortBy([]string{"_id"})
+	result, err := i.bleve.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range result.Hits {
+		rec := i.Record(match.ID)
+		if rec.Load() {
+			records = append(records, rec)
+		}
+	}
+
+	return
+}
+
+// Count returns the number of stored records
+func (i *bleveIndexer) Count() (uint64, error) {
+	return i.bleve.DocCount()
+}
+
+// Delete removes the record of the path from the index
+func (i *bleveIndexer) Delete(path string) error {
+	i.writes.RLock()
+	defer i.writes.RUnlock()
+	return i.bleve.Delete(path)
+}
+
+// indexFiles are the entries bleve writes in the index directory. The other
+// files kept there, like the crawl states of the sites, aren't snapshotted:
+// a restored index is scanned again from scratch.
+var indexFiles = map[string]bool{"index_meta.json": true, "store": true}
+
+func indexFile(name string) bool {
+	return indexFiles[strings.SplitN(name, "/", 2)[0]]
+}
+
+// Snapshot writes a point-in-time archive of the index directory. Writes wait
+// while the archive is written to a temporary file next to the index;
+// searches go on. The archive is then copied to w.
+func (i *bleveIndexer) Snapshot(w io.Writer) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(i.name), "."+filepath.Base(i.name)+"-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	i.writes.Lock()
+	err = indexer.WriteArchive(tmp, i.name, indexFile)
+	i.writes.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+	return err
+}
+
+// errClosed is reported to the records piped after Close
+var errClosed = errors.New("index closed")
+
+// drainTimeout bounds how long Close waits for queued records
+var drainTimeout = 10 * time.Second
+
+// Close stops accepting records, waits up to drainTimeout for the queued ones
+// to be indexed and closes the bleve index
+func (i *bleveIndexer) Close() error {
+	i.mutex.Lock()
+	if i.closed {
+		i.mutex.Unlock()
+		return nil
+	}
+	i.closed = true
+	i.mutex.Unlock()
+
+	deadline := time.Now().Add(drainTimeout)
+	for i.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	close(i.done)
+	return i.bleve.Close()
+}
+
 // index is the pipeline step that indexes the document
 func (i *bleveIndexer) index(in interface{}) interface{} {
 	if rec, ok := in.(*Record); ok {
+		var err error
 
 		if rec != nil && len(rec.body) > 0 && !rec.Ignored() {
-			rec.SetIndexed(time.Now())
-			fmt.Println(rec.FullPath())
+			if rec.Indexed().IsZero() {
+				rec.SetIndexed(time.Now())
+			}
 
 			r := indexRecord{
 				Path:     rec.Path(),
@@ -92,12 +319,28 @@ func (i *bleveIndexer) index(in interface{}) interface{} {
 				Body:     string(rec.body),
 				Modified: strconv.Itoa(int(rec.Modified().Unix())),
 				Indexed:  strconv.Itoa(int(rec.Indexed().Unix())),
+				Fields:   rec.Fields(),
 			}
 
-			i.bleve.Index(rec.Path(), r)
+			i.writes.RLock()
+			err = i.bleve.Index(rec.Path(), r)
+			i.writes.RUnlock()
+
+			if err != nil {
+				i.mutex.Lock()
+				i.failed++
+				i.lastError = rec.Path() + ": " + err.Error()
+				i.lastErrorTime = time.Now()
+				i.mutex.Unlock()
+			}
+		}
+
+		if rec.done != nil {
+			rec.done(err)
 		}
 
 		i.Kill(rec)
+		i.queue(-1)
 	}
 
 	return in
@@ -1 +1,62 @@
 package bleve_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedronasser/caddy-search/indexer"
+	"github.com/pedronasser/caddy-search/indexer/bleve"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func waitIndexed(h indexer.Handler) {
+	for h.Status().Queued > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	Convey("Given an index with documents", t, func() {
+		dir, _ := ioutil.TempDir("", "caddyIndexTest")
+		defer os.RemoveAll(dir)
+
+		src, err := bleve.New(filepath.Join(dir, "src"))
+		So(err, ShouldBeNil)
+		defer src.Close()
+
+		for _, path := range []string{"/a.html", "/b.html"} {
+			rec := src.Record(path)
+			rec.SetTitle("Title of " + path)
+			rec.Write([]byte("Body of " + path))
+			rec.SetField("MimeType", "text/html")
+			src.Pipe(rec)
+		}
+		waitIndexed(src)
+
+		Convey("Should replay its export into another index", func() {
+			var buf bytes.Buffer
+			n, err := indexer.Export(src, &buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			dst, err := bleve.New(filepath.Join(dir, "dst"))
+			So(err, ShouldBeNil)
+			defer dst.Close()
+
+			n, err = indexer.Import(dst, &buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			waitIndexed(dst)
+
+			rec, err := dst.Get("/b.html")
+			So(err, ShouldBeNil)
+			So(rec.Title(), ShouldEqual, "Title of /b.html")
+			So(string(rec.Body()), ShouldEqual, "Body of /b.html")
+			So(rec.Field("MimeType"), ShouldEqual, "text/html")
+		})
+	})
+}
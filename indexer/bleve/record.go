@@ -2,10 +2,14 @@ package bleve
 
 import (
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// fieldsPrefix is the prefix of extra fields' names in bleve's documents
+const fieldsPrefix = "Fields."
+
 // Record handles indexer's data
 type Record struct {
 	indexer  *bleveIndexer
@@ -13,12 +17,15 @@ type Record struct {
 	fullPath string
 	title    string
 	document map[string]interface{}
+	fields   map[string]string
 	body     []byte
 	loaded   bool
 	modified time.Time
 	mutex    sync.RWMutex
 	ignored  bool
 	indexed  time.Time
+	score    float64
+	done     func(error)
 }
 
 // Path returns Record's path
@@ -28,6 +35,13 @@ func (r *Record) Path() string {
 	return r.path
 }
 
+// SetPath replaces Record's path, which is its key in the index
+func (r *Record) SetPath(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.path = path
+}
+
 // FullPath returns Record's fullpath
 func (r *Record) FullPath() string {
 	r.mutex.RLock()
@@ -56,6 +70,35 @@ func (r *Record) SetTitle(title string) {
 	r.title = title
 }
 
+// Field returns the value of one of Record's extra fields
+func (r *Record) Field(name string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.fields[name]
+}
+
+// SetField defines the value of an extra field, an empty value removes it
+func (r *Record) SetField(name, value string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if value == "" {
+		delete(r.fields, name)
+		return
+	}
+	r.fields[name] = value
+}
+
+// Fields returns a copy of Record's extra fields
+func (r *Record) Fields() map[string]string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	fields := make(map[string]string, len(r.fields))
+	for name, value := range r.fields {
+		fields[name] = value
+	}
+	return fields
+}
+
 // Modified returns Record's Modified
 func (r *Record) Modified() time.Time {
 	r.mutex.RLock()
@@ -99,6 +142,10 @@ func (r *Record) Load() bool {
 		name := field.Name()
 		value := field.Value()
 		result[name] = value
+
+		if strings.HasPrefix(name, fieldsPrefix) {
+			r.fields[strings.TrimPrefix(name, fieldsPrefix)] = string(value)
+		}
 	}
 
 	strModified := string(result["Modified"].([]byte))
@@ -134,6 +181,15 @@ func (r *Record) Write(p []byte) (int, error) {
 	return len(r.body), nil
 }
 
+// OnIndexed registers fn to be called once the indexer is done with the
+// record: it stored it, failed to, or had nothing to store
+func (r *Record) OnIndexed(fn func(error)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.done = fn
+}
+
 // Ignore flag this record as ignored
 func (r *Record) Ignore() {
 	r.mutex.Lock()
@@ -165,3 +221,19 @@ func (r *Record) SetIndexed(index time.Time) {
 
 	r.indexed = index
 }
+
+// Score returns the relevance of this record for the last search
+func (r *Record) Score() float64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.score
+}
+
+// SetScore defines the relevance of this record for the last search
+func (r *Record) SetScore(score float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.score = score
+}
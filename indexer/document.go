@@ -0,0 +1,38 @@
+package indexer
+
+import "time"
+
+// Document is the engine neutral form of a Record
+type Document struct {
+	Path     string
+	Title    string
+	Body     string
+	Modified time.Time
+	Indexed  time.Time
+	Fields   map[string]string
+}
+
+// NewDocument copies the record into a Document
+func NewDocument(r Record) Document {
+	return Document{
+		Path:     r.Path(),
+		Title:    r.Title(),
+		Body:     string(r.Body()),
+		Modified: r.Modified(),
+		Indexed:  r.Indexed(),
+		Fields:   r.Fields(),
+	}
+}
+
+// Record creates a record of the handler holding the document
+func (d Document) Record(h Handler) Record {
+	r := h.Record(d.Path)
+	r.SetTitle(d.Title)
+	r.Write([]byte(d.Body))
+	r.SetModified(d.Modified)
+	r.SetIndexed(d.Indexed)
+	for name, value := range d.Fields {
+		r.SetField(name, value)
+	}
+	return r
+}
@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// exportBatch is the number of records listed at once while exporting
+const exportBatch = 100
+
+// maxDocumentLine bounds the size of a single imported document
+const maxDocumentLine = 64 << 20
+
+// Export writes every record of the handler as newline-delimited JSON
+// Documents, sorted by path, and returns how many were written
+func Export(h Handler, w io.Writer) (n int, err error) {
+	enc := json.NewEncoder(w)
+
+	for offset := 0; ; offset += exportBatch {
+		records, err := h.List(offset, exportBatch)
+		if err != nil {
+			return n, err
+		}
+
+		for _, record := range records {
+			doc := NewDocument(record)
+			h.Kill(record)
+			if err := enc.Encode(doc); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(records) < exportBatch {
+			return n, nil
+		}
+	}
+}
+
+// Import reads newline-delimited JSON Documents, as written by Export, and
+// pipes them into the handler. It returns how many were piped.
+func Import(h Handler, r io.Reader) (n int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxDocumentLine)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var doc Document
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return n, err
+		}
+		if doc.Path == "" {
+			continue
+		}
+
+		h.Pipe(doc.Record(h))
+		n++
+	}
+
+	return n, scanner.Err()
+}
@@ -1,16 +1,36 @@
 package indexer
 
 import (
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrNotFound is returned when a record isn't in the index
+var ErrNotFound = errors.New("record not found")
+
 // Handler ...
 type Handler interface {
 	Record(string) Record
-	Search(string) []Record
+	Search(Query) ([]Record, error)
 	Pipe(Record)
 	Kill(Record)
+	Get(string) (Record, error)
+	List(offset, limit int) ([]Record, error)
+	Count() (uint64, error)
+	Delete(string) error
+	Status() Status
+	Snapshot(io.Writer) error
+	Close() error
+}
+
+// Status reports the state of an index
+type Status struct {
+	Queued        int64
+	Failed        int64
+	Size          int64
+	LastError     string
+	LastErrorTime time.Time
 }
 
 // Config ...
@@ -23,16 +43,24 @@ type Config struct {
 type Record interface {
 	io.Writer
 	Path() string
+	SetPath(string)
 	FullPath() string
 	SetFullPath(string)
 	Title() string
 	SetTitle(string)
 	Body() []byte
 	SetBody([]byte)
+	Field(string) string
+	SetField(string, string)
+	Fields() map[string]string
 	SetModified(time.Time)
 	Modified() time.Time
 	Load() bool
 	Ignore()
 	Ignored() bool
 	Indexed() time.Time
+	SetIndexed(time.Time)
+	Score() float64
+	SetScore(float64)
+	OnIndexed(func(error))
 }
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query modes
+const (
+	// SimpleMode treats the query as plain text with optional quotes and
+	// -exclusions. It never fails to parse.
+	SimpleMode = "simple"
+	// AdvancedMode passes the query to the engine's own query-string syntax.
+	AdvancedMode = "advanced"
+)
+
+// Default operators used to join the terms of a simple query
+const (
+	OperatorAnd = "and"
+	OperatorOr  = "or"
+)
+
+// Query is a search request sent to a Handler
+type Query struct {
+	Text     string
+	Mode     string
+	Operator string
+	Size     int
+}
+
+// QueryError is returned by a Handler when an advanced query can't be parsed
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *QueryError) Error() string {
+	return "invalid query " + strconv.Quote(e.Query) + ": " + e.Err.Error()
+}
+
+// SimpleQuery is a plain text query split into its parts
+type SimpleQuery struct {
+	Terms    []string
+	Phrases  []string
+	Excluded []string
+}
+
+// Empty returns true when the query has nothing to match
+func (q SimpleQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// ParseSimple splits plain text into terms, "quoted phrases" and -exclusions.
+// Any character is accepted; an unbalanced quote runs to the end of the text.
+func ParseSimple(text string) (q SimpleQuery) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		exclude := false
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			exclude = true
+			i++
+		}
+
+		var word string
+		quoted := runes[i] == '"'
+		if quoted {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			word = strings.TrimSpace(string(runes[i+1 : end]))
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			word = string(runes[i:end])
+			i = end
+		}
+
+		if word == "" {
+			continue
+		}
+
+		switch {
+		case exclude:
+			q.Excluded = append(q.Excluded, word)
+		case quoted:
+			q.Phrases = append(q.Phrases, word)
+		default:
+			q.Terms = append(q.Terms, word)
+		}
+	}
+
+	return
+}
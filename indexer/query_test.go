@@ -0,0 +1,30 @@
+package indexer_test
+
+import (
+	"testing"
+
+	"github.com/pedronasser/caddy-search/indexer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSimple(t *testing.T) {
+	Convey("Given plain text queries", t, func() {
+		Convey("Should keep special characters as terms", func() {
+			q := indexer.ParseSimple(`C++ a:b`)
+			So(q.Terms, ShouldResemble, []string{"C++", "a:b"})
+		})
+		Convey("Should split phrases and exclusions", func() {
+			q := indexer.ParseSimple(`install "quick start" -windows -"old docs"`)
+			So(q.Terms, ShouldResemble, []string{"install"})
+			So(q.Phrases, ShouldResemble, []string{"quick start"})
+			So(q.Excluded, ShouldResemble, []string{"windows", "old docs"})
+		})
+		Convey("Should accept unbalanced quotes", func() {
+			q := indexer.ParseSimple(`"unbalanced quote`)
+			So(q.Phrases, ShouldResemble, []string{"unbalanced quote"})
+		})
+		Convey("Should be empty with only exclusions", func() {
+			So(indexer.ParseSimple(`-foo`).Empty(), ShouldBeTrue)
+		})
+	})
+}
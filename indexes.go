@@ -0,0 +1,146 @@
+package search
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// SiteField is the record field holding the host of the site that indexed
+// the document, telling apart the documents of the sites sharing an index
+const SiteField = "Site"
+
+// indexes holds the indexes opened in this process by directory, so that
+// site blocks sharing an index don't open it twice
+var indexes = struct {
+	sync.Mutex
+	m map[string]*sharedIndex
+}{m: make(map[string]*sharedIndex)}
+
+type sharedIndex struct {
+	engine  string
+	handler indexer.Handler
+	refs    int
+}
+
+// scanners holds the site reading each site root of a shared index, by
+// index directory and root
+var scanners = struct {
+	sync.Mutex
+	m map[string]*Search
+}{m: make(map[string]*Search)}
+
+// scanKey identifies the files of the site's root in its index
+func scanKey(c *Config) string {
+	return indexPath(c.indexConfig()) + string(filepath.ListSeparator) + filepath.Clean(c.SiteRoot)
+}
+
+// claimScan returns true if the site is the one to scan and watch its root,
+// because no other site sharing its index reads the same root. A reloaded
+// site takes over from its previous instance.
+func claimScan(s *Search) bool {
+	scanners.Lock()
+	defer scanners.Unlock()
+
+	key := scanKey(s.Config)
+	if current, ok := scanners.m[key]; ok && current.Config.Host != s.Config.Host {
+		return false
+	}
+	scanners.m[key] = s
+	return true
+}
+
+// releaseScan lets another site scan the root if the site was scanning it
+func releaseScan(s *Search) {
+	scanners.Lock()
+	key := scanKey(s.Config)
+	if scanners.m[key] == s {
+		delete(scanners.m, key)
+	}
+	scanners.Unlock()
+}
+
+// indexPath returns the directory of the index described by the config
+func indexPath(config indexer.Config) string {
+	return filepath.Clean(config.IndexDirectory + string(filepath.Separator) + config.HostName)
+}
+
+// IndexName returns the default index name of a site's host
+func IndexName(host string) string {
+	hosthash := md5.New()
+	hosthash.Write([]byte(host))
+	return hex.EncodeToString(hosthash.Sum(nil))
+}
+
+// RestoreIndex extracts the snapshot archive into the directory of the index
+// described by the config, unless the index already exists. It returns true
+// if the index was restored.
+func RestoreIndex(archive string, config indexer.Config) (bool, error) {
+	dir := indexPath(config)
+	if entries, err := filepath.Glob(filepath.Join(dir, "*")); err == nil && len(entries) > 0 {
+		return false, nil
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := indexer.ExtractArchive(f, dir); err != nil {
+		os.RemoveAll(dir)
+		return false, err
+	}
+	return true, nil
+}
+
+// OpenIndex returns the index described by the config, opening it with the
+// engine unless it is already open. Every OpenIndex must be matched by a
+// ReleaseIndex.
+func OpenIndex(engine string, config indexer.Config) (indexer.Handler, error) {
+	indexes.Lock()
+	defer indexes.Unlock()
+
+	name := indexPath(config)
+	if shared, ok := indexes.m[name]; ok {
+		if shared.engine != engine {
+			return nil, fmt.Errorf("index %s is already open with engine %s", name, shared.engine)
+		}
+		shared.refs++
+		return shared.handler, nil
+	}
+
+	handler, err := NewIndexer(engine, config)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes.m[name] = &sharedIndex{engine: engine, handler: handler, refs: 1}
+	return handler, nil
+}
+
+// ReleaseIndex releases an index returned by OpenIndex and closes it when
+// no site uses it anymore
+func ReleaseIndex(config indexer.Config) error {
+	indexes.Lock()
+	defer indexes.Unlock()
+
+	name := indexPath(config)
+	shared, ok := indexes.m[name]
+	if !ok {
+		return nil
+	}
+
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+
+	delete(indexes.m, name)
+	return shared.handler.Close()
+}
@@ -0,0 +1,76 @@
+package search_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSharedIndex(t *testing.T) {
+	Convey("Given two sites sharing an index and a root", t, func() {
+		site := newTestSite(map[string]string{
+			"page.html": "<title>Page</title><p>Shared page</p>",
+		})
+		Reset(site.close)
+
+		config := &search.Config{
+			Host:           "other.com",
+			HostName:       site.config.HostName,
+			IndexDirectory: site.config.IndexDirectory,
+			SiteRoot:       site.root,
+			IncludePaths:   site.config.IncludePaths,
+		}
+		pipeline, err := search.NewPipeline(config, site.index)
+		So(err, ShouldBeNil)
+		other := &search.Search{Config: config, Indexer: site.index, Pipeline: pipeline}
+		Reset(func() {
+			search.ReleaseScan(site.search)
+			search.ReleaseScan(other)
+		})
+
+		Convey("Should let only one of them scan the root", func() {
+			So(search.ClaimScan(site.search), ShouldBeTrue)
+			So(search.ClaimScan(other), ShouldBeFalse)
+
+			search.ReleaseScan(other)
+			So(search.ClaimScan(other), ShouldBeFalse)
+
+			search.ReleaseScan(site.search)
+			So(search.ClaimScan(other), ShouldBeTrue)
+		})
+
+		Convey("Should let a reloaded site take over its scans", func() {
+			reloaded := &search.Search{Config: site.config, Indexer: site.index, Pipeline: site.pipeline}
+			So(search.ClaimScan(site.search), ShouldBeTrue)
+			So(search.ClaimScan(reloaded), ShouldBeTrue)
+
+			search.ReleaseScan(site.search)
+			So(search.ClaimScan(other), ShouldBeFalse)
+			search.ReleaseScan(reloaded)
+		})
+
+		Convey("Should keep the documents to the site that indexed them first", func() {
+			search.ScanToPipe(site.root, site.pipeline, site.index)
+			So(eventually(func() bool { return site.indexed("/page.html") }), ShouldBeTrue)
+
+			ioutil.WriteFile(filepath.Join(site.root, "page.html"), []byte("<title>Page</title><p>Changed page</p>"), 0644)
+			search.ScanToPipe(site.root, pipeline, site.index)
+
+			var owner string
+			So(eventually(func() bool {
+				record := site.index.Record("/page.html")
+				defer site.index.Kill(record)
+				if !record.Load() {
+					return false
+				}
+				owner = record.Field(search.SiteField)
+				return strings.Contains(string(record.Body()), "Changed page")
+			}), ShouldBeTrue)
+			So(owner, ShouldEqual, "example.com")
+		})
+	})
+}
@@ -0,0 +1,192 @@
+package search
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Buckets of the exported histograms
+var (
+	queryDurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+	resultCountBuckets   = []float64{0, 1, 5, 10, 25, 50}
+	scanDurationBuckets  = []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900}
+)
+
+// histogram is a cumulative histogram in Prometheus' sense. It is guarded by
+// the mutex of the Stats holding it.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	for i, le := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(le), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelValue escapes a label value for the text format
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// stageOutcomes maps the reasons for ignoring documents to the pipeline
+// stage and outcome they are exported as
+var stageOutcomes = map[string][2]string{
+	IgnoredReadError:    {"read", "failed"},
+	IgnoredStatus:       {"validate", "ignored"},
+	IgnoredExcluded:     {"validate", "ignored"},
+	IgnoredUnchanged:    {"validate", "skipped"},
+	IgnoredUnsupported:  {"parse", "ignored"},
+	IgnoredExtractError: {"parse", "failed"},
+	IgnoredDuplicate:    {"dedupe", "ignored"},
+	IgnoredRobots:       {"parse", "ignored"},
+}
+
+// ServeMetrics is the HTTP handler exporting the metrics of every site of
+// this process in the Prometheus text format. Requests must send the admin
+// token.
+func (s *Search) ServeMetrics(w http.ResponseWriter, r *http.Request) (int, error) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="search"`)
+		return http.StatusUnauthorized, nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+	return http.StatusOK, nil
+}
+
+// WriteMetrics writes the metrics of every site of this process
+func WriteMetrics(w io.Writer) {
+	sites.RLock()
+	hosts := make([]string, 0, len(sites.m))
+	for host := range sites.m {
+		hosts = append(hosts, host)
+	}
+	searches := make(map[string]*Search, len(sites.m))
+	for host, s := range sites.m {
+		searches[host] = s
+	}
+	sites.RUnlock()
+	sort.Strings(hosts)
+
+	// sites sharing an index report its indexer once, by index name
+	var indexSites []*Search
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		s := searches[host]
+		if dir := indexPath(s.Config.indexConfig()); !seen[dir] {
+			seen[dir] = true
+			indexSites = append(indexSites, s)
+		}
+	}
+
+	family := func(name, kind, help string, each func(host string, s *Search)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, host := range hosts {
+			each(host, searches[host])
+		}
+	}
+	indexFamily := func(name, kind, help string, each func(index string, s *Search)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range indexSites {
+			each(s.Config.HostName, s)
+		}
+	}
+
+	family("caddy_search_requests_total", "counter", "Search requests served.", func(host string, s *Search) {
+		s.Stats.mutex.RLock()
+		defer s.Stats.mutex.RUnlock()
+		formats := make([]string, 0, len(s.Stats.requests))
+		for format := range s.Stats.requests {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+		for _, format := range formats {
+			fmt.Fprintf(w, "caddy_search_requests_total{site=\"%s\",format=\"%s\"} %d\n", labelValue(host), format, s.Stats.requests[format])
+		}
+	})
+
+	family("caddy_search_query_duration_seconds", "histogram", "Time spent running search queries.", func(host string, s *Search) {
+		s.Stats.mutex.RLock()
+		defer s.Stats.mutex.RUnlock()
+		s.Stats.queryDuration.write(w, "caddy_search_query_duration_seconds", fmt.Sprintf("site=\"%s\"", labelValue(host)))
+	})
+
+	family("caddy_search_results", "histogram", "Number of results returned by search queries.", func(host string, s *Search) {
+		s.Stats.mutex.RLock()
+		defer s.Stats.mutex.RUnlock()
+		s.Stats.resultCount.write(w, "caddy_search_results", fmt.Sprintf("site=\"%s\"", labelValue(host)))
+	})
+
+	family("caddy_search_documents_total", "counter", "Documents leaving each pipeline stage, by outcome.", func(host string, s *Search) {
+		counts := make(map[[2]string]int64)
+
+		s.Stats.mutex.RLock()
+		counts[[2]string{"index", "indexed"}] = s.Stats.indexed
+		counts[[2]string{"index", "removed"}] = s.Stats.removed
+		for reason, n := range s.Stats.ignored {
+			if so, ok := stageOutcomes[reason]; ok {
+				counts[so] += n
+			}
+		}
+		s.Stats.mutex.RUnlock()
+
+		keys := make([][2]string, 0, len(counts))
+		for key := range counts {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+		})
+		for _, key := range keys {
+			fmt.Fprintf(w, "caddy_search_documents_total{site=\"%s\",stage=\"%s\",outcome=\"%s\"} %d\n", labelValue(host), key[0], key[1], counts[key])
+		}
+	})
+
+	family("caddy_search_queue_depth", "gauge", "Documents waiting in the pipelines.", func(host string, s *Search) {
+		fmt.Fprintf(w, "caddy_search_queue_depth{site=\"%s\"} %d\n", labelValue(host), s.Stats.Queued())
+	})
+
+	indexFamily("caddy_search_index_queue_depth", "gauge", "Documents waiting in the indexers.", func(index string, s *Search) {
+		fmt.Fprintf(w, "caddy_search_index_queue_depth{index=\"%s\"} %d\n", labelValue(index), s.Indexer.Status().Queued)
+	})
+
+	indexFamily("caddy_search_index_failures_total", "counter", "Documents the indexers failed to index.", func(index string, s *Search) {
+		fmt.Fprintf(w, "caddy_search_index_failures_total{index=\"%s\"} %d\n", labelValue(index), s.Indexer.Status().Failed)
+	})
+
+	family("caddy_search_scan_duration_seconds", "histogram", "Time spent walking the site root in full scans.", func(host string, s *Search) {
+		s.Stats.mutex.RLock()
+		defer s.Stats.mutex.RUnlock()
+		s.Stats.scanDuration.write(w, "caddy_search_scan_duration_seconds", fmt.Sprintf("site=\"%s\"", labelValue(host)))
+	})
+}
@@ -0,0 +1,58 @@
+package search_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Given two sites sharing an index", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		config := search.NewConfig("other.com", site.root)
+		config.IndexDirectory = site.config.IndexDirectory
+		config.HostName = site.config.HostName
+		pipeline, err := search.NewPipeline(config, site.index)
+		So(err, ShouldBeNil)
+		other := &search.Search{Config: config, Indexer: site.index, Pipeline: pipeline}
+
+		search.RegisterSite(site.config.Host, site.search)
+		search.RegisterSite(config.Host, other)
+		Reset(func() {
+			search.UnregisterSite(site.config.Host, site.search)
+			search.UnregisterSite(config.Host, other)
+			other.Stop()
+		})
+
+		site.search.SearchJSON(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?q=page", nil))
+
+		var buf bytes.Buffer
+		search.WriteMetrics(&buf)
+		metrics := buf.String()
+
+		Convey("Should describe every metric family", func() {
+			So(metrics, ShouldContainSubstring, "# HELP caddy_search_requests_total Search requests served.\n# TYPE caddy_search_requests_total counter\n")
+			So(metrics, ShouldContainSubstring, "# TYPE caddy_search_query_duration_seconds histogram\n")
+		})
+
+		Convey("Should report the metrics of each site", func() {
+			So(metrics, ShouldContainSubstring, "caddy_search_requests_total{site=\"example.com\",format=\"json\"} 1\n")
+			So(metrics, ShouldContainSubstring, "caddy_search_results_bucket{site=\"example.com\",le=\"+Inf\"} 1\n")
+			So(metrics, ShouldContainSubstring, "caddy_search_results_count{site=\"example.com\"} 1\n")
+			So(metrics, ShouldContainSubstring, "caddy_search_queue_depth{site=\"example.com\"} 0\n")
+			So(metrics, ShouldContainSubstring, "caddy_search_queue_depth{site=\"other.com\"} 0\n")
+		})
+
+		Convey("Should report a shared index once", func() {
+			So(strings.Count(metrics, "caddy_search_index_queue_depth{"), ShouldEqual, 1)
+			So(metrics, ShouldContainSubstring, "caddy_search_index_queue_depth{index=\""+site.config.HostName+"\"} 0\n")
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// MimeTypeField is the record field holding the document's MIME type
+const MimeTypeField = "MimeType"
+
+// extensionTypes are the types of the extensions mime.TypeByExtension may
+// not know, depending on the system's MIME database
+var extensionTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".txt":      "text/plain",
+}
+
+// detectType returns the MIME type of the document: the Content-Type of a
+// dynamic page, or else the type of its extension, or else the type sniffed
+// from its content
+func detectType(record indexer.Record) string {
+	if mimeType := mediaType(record.Field(MimeTypeField)); mimeType != "" {
+		return mimeType
+	}
+
+	ext := strings.ToLower(path.Ext(pagePath(record.Path())))
+	if mimeType, ok := extensionTypes[ext]; ok {
+		return mimeType
+	}
+	if mimeType := mediaType(mime.TypeByExtension(ext)); mimeType != "" {
+		return mimeType
+	}
+
+	return mediaType(http.DetectContentType(record.Body()))
+}
+
+// mediaType returns the media type of a Content-Type, without its
+// parameters
+func mediaType(contentType string) string {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mimeType
+}
+
+// pagePath returns the path of a request path, without its query string
+func pagePath(reqPath string) string {
+	if i := strings.IndexByte(reqPath, '?'); i >= 0 {
+		return reqPath[:i]
+	}
+	return reqPath
+}
+
+// pageName returns the title of documents without one: the name of their
+// file, or their path for directory pages
+func pageName(reqPath string) string {
+	p := pagePath(reqPath)
+	if p == "" || strings.HasSuffix(p, "/") {
+		return p
+	}
+	return path.Base(p)
+}
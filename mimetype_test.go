@@ -0,0 +1,115 @@
+package search_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	htmlPage  = "<!DOCTYPE html><html><head><title>Page</title></head><body><p>Some text</p></body></html>"
+	plainText = "Some plain text"
+)
+
+// plainWriter discards the response without sniffing its Content-Type, as
+// httptest.ResponseRecorder would
+type plainWriter struct {
+	header http.Header
+}
+
+func (w *plainWriter) Header() http.Header         { return w.header }
+func (w *plainWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *plainWriter) WriteHeader(int)             {}
+
+// mimeType returns the MIME type the path was indexed with
+func (site *testSite) mimeType(path string) string {
+	record, err := site.index.Get(path)
+	if err != nil {
+		return ""
+	}
+	defer site.index.Kill(record)
+	return record.Field(search.MimeTypeField)
+}
+
+func TestDetectType(t *testing.T) {
+	Convey("Given static files", t, func() {
+		tests := []struct {
+			name     string
+			file     string
+			content  string
+			mimeType string
+		}{
+			{"by extension", "page.html", plainText, "text/html"},
+			{"by uppercase extension", "PAGE.HTM", plainText, "text/html"},
+			{"by extension unknown to the system", "notes.md", htmlPage, "text/markdown"},
+			{"by sniffing HTML without extension", "index", htmlPage, "text/html"},
+			{"by sniffing text with an unknown extension", "notes.unknown", plainText, "text/plain"},
+		}
+
+		files := make(map[string]string)
+		for _, test := range tests {
+			files[test.file] = test.content
+		}
+		site := newTestSite(files)
+		Reset(site.close)
+
+		search.ScanToPipe(site.root, site.pipeline, site.index)
+		site.settle()
+
+		for _, test := range tests {
+			test := test
+			Convey("Should detect the type "+test.name, func() {
+				So(site.mimeType("/"+test.file), ShouldEqual, test.mimeType)
+			})
+		}
+	})
+
+	Convey("Given dynamic pages", t, func() {
+		tests := []struct {
+			name        string
+			path        string
+			contentType string
+			content     string
+			mimeType    string
+		}{
+			{"from the Content-Type", "/page", "text/plain", htmlPage, "text/plain"},
+			{"from the Content-Type over the extension", "/page.html", "text/plain", htmlPage, "text/plain"},
+			{"without the parameters", "/charset", "text/html; charset=utf-8", htmlPage, "text/html"},
+			{"in lowercase", "/upper", "TEXT/HTML; Charset=UTF-8", htmlPage, "text/html"},
+			{"from the extension without Content-Type", "/notes.txt", "", htmlPage, "text/plain"},
+			{"by sniffing without Content-Type nor extension", "/sniffed", "", htmlPage, "text/html"},
+		}
+
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		contentTypes, contents := make(map[string]string), make(map[string]string)
+		for _, test := range tests {
+			contentTypes[test.path], contents[test.path] = test.contentType, test.content
+		}
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			if contentType := contentTypes[r.URL.Path]; contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Write([]byte(contents[r.URL.Path]))
+			return http.StatusOK, nil
+		})
+
+		for _, test := range tests {
+			w := &plainWriter{header: http.Header{}}
+			site.search.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		}
+
+		for _, test := range tests {
+			test := test
+			Convey("Should read the type "+test.name, func() {
+				So(eventually(func() bool { return site.mimeType(test.path) != "" }), ShouldBeTrue)
+				So(site.mimeType(test.path), ShouldEqual, test.mimeType)
+			})
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package search
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// CanonicalField is the record field holding the path a page was requested
+// at when its canonical link stored it under another path
+const CanonicalField = "Canonical"
+
+// NormalizeURL returns the URL documents are stored under: the host is
+// lowercased, the fragment and the parameters matching Config.StripParams
+// are dropped and the remaining parameters are sorted
+func (c *Config) NormalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	n.Fragment = ""
+
+	if n.RawQuery != "" {
+		query := n.Query()
+		for name := range query {
+			if c.stripParam(name) {
+				query.Del(name)
+			}
+		}
+		// Encode sorts by name
+		n.RawQuery = query.Encode()
+	}
+
+	return n.String()
+}
+
+// stripParam returns true if the query parameter matches one of the
+// patterns of Config.StripParams
+func (c *Config) stripParam(name string) bool {
+	for _, pattern := range c.StripParams {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvePath resolves the reference (a link, a sitemap location) against
+// the request path of the page holding it, and returns the normalized
+// request path it points to if it's on this site
+func (c *Config) ResolvePath(base, ref string) (string, bool) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", false
+	}
+	r, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", false
+	}
+
+	u := b.ResolveReference(r)
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	if u.Host != "" && c.Host != "" && hostname(u.Host) != hostname(c.Host) {
+		return "", false
+	}
+
+	u.Scheme, u.Host, u.User, u.Opaque = "", "", nil, ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return c.NormalizeURL(u), true
+}
+
+// hostname strips the port of the host and lowercases it
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
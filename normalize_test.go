@@ -0,0 +1,126 @@
+package search_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	Convey("Given a config stripping tracking parameters", t, func() {
+		config := &search.Config{Host: "example.com", StripParams: []string{"utm_*", "fbclid"}}
+
+		normalize := func(raw string) string {
+			u, _ := url.Parse(raw)
+			return config.NormalizeURL(u)
+		}
+
+		Convey("Should strip the listed parameters and sort the rest", func() {
+			So(normalize("/page?b=2&utm_source=mail&a=1&fbclid=x"), ShouldEqual, "/page?a=1&b=2")
+			So(normalize("/page?utm_medium=social"), ShouldEqual, "/page")
+		})
+
+		Convey("Should drop fragments and lowercase the host", func() {
+			So(normalize("http://Example.COM/Page#top"), ShouldEqual, "http://example.com/Page")
+		})
+
+		Convey("Should resolve links on this site", func() {
+			path, ok := config.ResolvePath("/blog/post?id=1", "other?utm_campaign=x#comments")
+			So(ok, ShouldBeTrue)
+			So(path, ShouldEqual, "/blog/other")
+
+			path, ok = config.ResolvePath("/", "https://EXAMPLE.com:443/about")
+			So(ok, ShouldBeTrue)
+			So(path, ShouldEqual, "/about")
+		})
+
+		Convey("Should not resolve links to other sites", func() {
+			_, ok := config.ResolvePath("/", "https://other.com/about")
+			So(ok, ShouldBeFalse)
+			_, ok = config.ResolvePath("/", "mailto:someone@example.com")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCanonicalize(t *testing.T) {
+	Convey("Given dynamic pages with canonical links", t, func() {
+		site := newTestSite(map[string]string{
+			"static.html": "<title>Static</title><p>Static page</p>",
+		})
+		Reset(site.close)
+
+		site.config.ExcludePaths = search.ConvertToRegExp([]string{"^/private"})
+		search.ScanToPipe(site.root, site.pipeline, site.index)
+		site.settle()
+
+		canonical := ""
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<link rel="canonical" href="%s"><title>Copy</title><p>Copied page</p>`, canonical)
+			return http.StatusOK, nil
+		})
+		serve := func(href string) {
+			canonical = href
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/copy", nil))
+			site.settle()
+		}
+		source := func(path string) string {
+			record, err := site.index.Get(path)
+			if err != nil {
+				return ""
+			}
+			defer site.index.Kill(record)
+			return record.Field(search.SourceField)
+		}
+
+		Convey("Should store the page under its canonical path", func() {
+			serve("http://example.com/original")
+			So(eventually(func() bool { return site.indexed("/original") }), ShouldBeTrue)
+			So(site.indexed("/copy"), ShouldBeFalse)
+		})
+
+		Convey("Should ignore canonical links to other sites", func() {
+			serve("http://other.com/original")
+			So(eventually(func() bool { return site.indexed("/copy") }), ShouldBeTrue)
+		})
+
+		Convey("Should ignore canonical links to paths that can't be indexed", func() {
+			serve("/private/original")
+			So(eventually(func() bool { return site.indexed("/copy") }), ShouldBeTrue)
+			So(site.indexed("/private/original"), ShouldBeFalse)
+		})
+
+		Convey("Should never replace static documents", func() {
+			serve("/static.html")
+			So(eventually(func() bool { return site.indexed("/copy") }), ShouldBeTrue)
+			So(source("/static.html"), ShouldEqual, search.SourceStatic)
+		})
+	})
+
+	Convey("Given a dynamic page stored before its parameters were stripped", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<title>Page</title><p>Dynamic page</p>"))
+			return http.StatusOK, nil
+		})
+		site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page?utm_source=mail", nil))
+		So(eventually(func() bool { return site.indexed("/page?utm_source=mail") }), ShouldBeTrue)
+
+		Convey("Should remove it at the next scan", func() {
+			site.config.StripParams = []string{"utm_*"}
+			search.ScanToPipe(site.root, site.pipeline, site.index)
+			site.settle()
+			So(site.indexed("/page?utm_source=mail"), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// pinnedCheckInterval is how often the pinned file is checked for changes
+var pinnedCheckInterval = 2 * time.Second
+
+// Pin is a page that must appear at the top of the results of matching
+// queries. A pin matches when the whole query equals one of its Queries or
+// when any word of the query equals one of its Keywords.
+type Pin struct {
+	Queries     []string `json:"queries"`
+	Keywords    []string `json:"keywords"`
+	Path        string   `json:"path"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+}
+
+// Pins holds the pinned results loaded from a JSON file. Reload loads the
+// file again whenever its modification time changes.
+type Pins struct {
+	file    string
+	mutex   sync.RWMutex
+	pins    []Pin
+	modTime time.Time
+	checked time.Time
+}
+
+// NewPins loads the pinned results from the given file
+func NewPins(file string) (*Pins, error) {
+	p := &Pins{file: file}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pins) load(modTime time.Time) error {
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+
+	var pins []Pin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.pins = pins
+	p.modTime = modTime
+	p.mutex.Unlock()
+	return nil
+}
+
+// Reload loads the file again if it has changed, at most once every
+// pinnedCheckInterval. A file that became invalid keeps the last good pins.
+func (p *Pins) Reload() error {
+	p.mutex.Lock()
+	if time.Since(p.checked) < pinnedCheckInterval {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.checked = time.Now()
+	modTime := p.modTime
+	p.mutex.Unlock()
+
+	info, err := os.Stat(p.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(modTime) {
+		return nil
+	}
+	return p.load(info.ModTime())
+}
+
+// Match returns the pins for the query, in file order
+func (p *Pins) Match(q string) (matches []Pin) {
+	text := normalizeQuery(q)
+	if text == "" {
+		return
+	}
+
+	words := map[string]bool{}
+	sq := indexer.ParseSimple(text)
+	for _, w := range append(sq.Terms, sq.Phrases...) {
+		words[w] = true
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, pin := range p.pins {
+		if pin.matches(text, words) {
+			matches = append(matches, pin)
+		}
+	}
+	return
+}
+
+func (pin Pin) matches(text string, words map[string]bool) bool {
+	for _, q := range pin.Queries {
+		if normalizeQuery(q) == text {
+			return true
+		}
+	}
+	for _, k := range pin.Keywords {
+		if words[normalizeQuery(k)] {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeQuery lowercases the query and collapses its whitespace
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
@@ -0,0 +1,41 @@
+package search_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPins(t *testing.T) {
+	Convey("Given a pinned results file", t, func() {
+		f, err := ioutil.TempFile("", "pinned")
+		So(err, ShouldBeNil)
+		defer os.Remove(f.Name())
+
+		f.WriteString(`[
+			{"queries": ["getting started"], "path": "/docs/start"},
+			{"keywords": ["install", "setup"], "path": "/docs/install", "title": "Installing"}
+		]`)
+		f.Close()
+
+		pins, err := search.NewPins(f.Name())
+		So(err, ShouldBeNil)
+
+		Convey("Should match whole queries regardless of case and spacing", func() {
+			matches := pins.Match("  Getting   STARTED ")
+			So(len(matches), ShouldEqual, 1)
+			So(matches[0].Path, ShouldEqual, "/docs/start")
+		})
+		Convey("Should match keywords inside the query", func() {
+			matches := pins.Match("how to install caddy")
+			So(len(matches), ShouldEqual, 1)
+			So(matches[0].Title, ShouldEqual, "Installing")
+		})
+		Convey("Should not match unrelated queries", func() {
+			So(pins.Match("getting"), ShouldBeEmpty)
+		})
+	})
+}
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"io"
 	"os"
-	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/microcosm-cc/bluemonday"
@@ -19,14 +20,18 @@ var bm = bluemonday.UGCPolicy()
 // NewPipeline creates a new Pipeline instance
 func NewPipeline(config *Config, indxr indexer.Handler) (*Pipeline, error) {
 	ppl := &Pipeline{
-		config:  config,
-		indexer: indxr,
+		config:     config,
+		indexer:    indxr,
+		Duplicates: NewDuplicates(config.DuplicateDistance),
+		Stats:      NewStats(),
+		waiters:    make(map[indexer.Record]*pageWaiter),
 	}
 
 	pipe, err := piper.New(
 		piper.P(1, ppl.read),
 		piper.P(1, ppl.validate),
 		piper.P(1, ppl.parse),
+		piper.P(1, ppl.dedupe),
 		piper.P(1, ppl.index),
 	)
 
@@ -35,19 +40,29 @@ func NewPipeline(config *Config, indxr indexer.Handler) (*Pipeline, error) {
 	}
 
 	ppl.pipe = pipe
+	ppl.done = make(chan struct{})
 
+	// once the pipeline is closed, the output is still drained until the
+	// documents left in the stages are out, so that none stays blocked in a
+	// stage
 	go func() {
 		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
 		out := pipe.Output()
+		done := ppl.done
 		for {
 			select {
 			case in := <-out:
+				ppl.Stats.Queue(-1)
 				if record, ok := in.(indexer.Record); ok {
-					if record.Ignored() {
-						ppl.indexer.Kill(record)
-					}
+					ppl.indexer.Kill(record)
 				}
 			case <-tick.C:
+			case <-done:
+				done = nil
+			}
+			if done == nil && ppl.Stats.Queued() == 0 {
+				return
 			}
 		}
 	}()
@@ -57,14 +72,93 @@ func NewPipeline(config *Config, indxr indexer.Handler) (*Pipeline, error) {
 
 // Pipeline is the structure that holds search's pipeline infos and methods
 type Pipeline struct {
-	config  *Config
-	indexer indexer.Handler
-	pipe    piper.Handler
+	// indexing counts the documents piped to the indexer it isn't done
+	// with. It comes first to be aligned for atomic operations.
+	indexing int64
+
+	config     *Config
+	indexer    indexer.Handler
+	pipe       piper.Handler
+	Duplicates *Duplicates
+	Stats      *Stats
+	Crawl      *CrawlState
+
+	// Indexed, if set, is called with the path of each dynamic page of the
+	// site once the indexer stored it
+	Indexed func(path string)
+
+	mutex     sync.Mutex
+	waiters   map[indexer.Record]*pageWaiter
+	closed    bool
+	closeOnce sync.Once
+	staleOnce sync.Once
+	done      chan struct{}
 }
 
+// drainTimeout bounds how long Close waits for queued documents
+var drainTimeout = 10 * time.Second
+
 // Pipe is the step of the pipeline that pipes valid documents to the indexer.
+// Documents piped after Close are dropped.
 func (p *Pipeline) Pipe(record indexer.Record) {
+	p.send(record)
+}
+
+// send pipes the record and returns false if the pipeline is closed
+func (p *Pipeline) send(record indexer.Record) bool {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		p.indexer.Kill(record)
+		return false
+	}
+	p.Stats.Queue(1)
+	p.mutex.Unlock()
+
 	p.pipe.Input() <- record
+	return true
+}
+
+// Close stops accepting documents and waits up to drainTimeout for the queued
+// ones to reach the indexer. Documents still in the pipeline after that are
+// drained in the background, and dropped if their index was closed meanwhile.
+func (p *Pipeline) Close() {
+	p.closeOnce.Do(func() {
+		p.mutex.Lock()
+		p.closed = true
+		p.mutex.Unlock()
+
+		p.Wait(drainTimeout)
+		close(p.done)
+
+		if p.Crawl != nil {
+			if err := p.Crawl.Save(); err != nil {
+				p.Stats.Error(err)
+			}
+		}
+	})
+}
+
+// Wait waits until no document is queued in the pipeline or waiting for the
+// indexer, or the timeout expires, and returns true if the pipeline was
+// drained
+func (p *Pipeline) Wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for p.Stats.Queued() > 0 || atomic.LoadInt64(&p.indexing) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// ignore flags the record as ignored and counts it for the reason
+func (p *Pipeline) ignore(record indexer.Record, reason string) {
+	if !record.Ignored() {
+		record.Ignore()
+		p.Stats.Ignored(reason)
+	}
 }
 
 // Piper is a func that returns the piper.Handler
@@ -72,96 +166,438 @@ func (p *Pipeline) Piper() piper.Handler {
 	return p.pipe
 }
 
-// validate is the step of the pipeline that reads the file content
+// read is the step of the pipeline that reads the file content of static
+// documents. Dynamic documents already hold the response body.
 func (p *Pipeline) read(in interface{}) interface{} {
-	if record, ok := in.(indexer.Record); ok && !record.Ignored() {
+	if record, ok := in.(indexer.Record); ok && !record.Ignored() && record.FullPath() != "" {
 		in, err := os.Open(record.FullPath())
-		defer in.Close()
 
 		if err != nil {
-			record.Ignore()
+			p.ignore(record, IgnoredReadError)
+			p.Stats.Error(err)
 		} else {
-			io.Copy(record, in)
+			defer in.Close()
+			if _, err := io.Copy(record, in); err != nil {
+				p.ignore(record, IgnoredReadError)
+				p.Stats.Error(err)
+			} else {
+				p.checkChanged(record)
+			}
 		}
 	}
 
 	return in
 }
 
+// checkChanged hashes the content of a static file just read and ignores the
+// file if the content didn't change since it was last indexed
+func (p *Pipeline) checkChanged(record indexer.Record) {
+	hash := contentHash(record.Body())
+	record.SetField(HashField, hash)
+
+	if p.Crawl == nil {
+		return
+	}
+
+	changed := p.Crawl.Read(record.Path(), FileState{
+		Modified: record.Modified(),
+		Size:     int64(len(record.Body())),
+		Hash:     hash,
+	})
+
+	if changed {
+		p.Stats.Updated()
+	} else {
+		record.Ignore()
+		p.Stats.Skipped()
+	}
+}
+
+// skip counts a static file skipped by a scan because it didn't change. Its
+// stored fingerprint is registered since it won't go through dedupe.
+func (p *Pipeline) skip(path string) {
+	p.Stats.Skipped()
+
+	if p.config.Duplicates == "" {
+		return
+	}
+
+	record, err := p.indexer.Get(path)
+	if err != nil {
+		return
+	}
+	if fp, ok := parseFingerprint(record.Field(FingerprintField)); ok {
+		p.Duplicates.Add(path, fp)
+	}
+	p.indexer.Kill(record)
+}
+
 // validate is the step of the pipeline that checks if documents are valid for
 // being indexed
 func (p *Pipeline) validate(in interface{}) interface{} {
 	if record, ok := in.(indexer.Record); ok && !record.Ignored() {
 		if !p.ValidatePath(record.Path()) {
-			record.Ignore()
+			p.ignore(record, IgnoredExcluded)
+		}
+
+		access, ruled := p.config.Access(record.Path())
+		if ruled {
+			record.SetField(AccessField, access)
+		}
+		p.inherit(record, !ruled && record.Field(AccessField) == "")
+
+		if !record.Ignored() && record.FullPath() == "" {
+			p.checkUnchanged(record)
 		}
 	}
 
 	return in
 }
 
-var titleTag = []byte("title")
+// inherit gives the document what it keeps from its indexed copy: the site
+// that indexed it first, so that sites sharing the index don't take over each
+// other's documents, and its access if keepAccess, so that content once
+// indexed for some users doesn't become public when it's indexed again without
+// credentials, by a scan or an anonymous request
+func (p *Pipeline) inherit(record indexer.Record, keepAccess bool) {
+	site := p.config.Host
+	if stored, err := p.indexer.Get(record.Path()); err == nil {
+		if keepAccess {
+			record.SetField(AccessField, stored.Field(AccessField))
+		}
+		if owner := stored.Field(SiteField); owner != "" {
+			site = owner
+		}
+		p.indexer.Kill(stored)
+	}
+	if site != "" {
+		record.SetField(SiteField, site)
+	}
+}
+
+// checkUnchanged hashes the content of a dynamic page and ignores the page if
+// its indexed copy has the same content. The hashes decide; the ETag, or else
+// the Last-Modified time, only decide for copies indexed without a hash.
+func (p *Pipeline) checkUnchanged(record indexer.Record) {
+	hash := contentHash(record.Body())
+	record.SetField(HashField, hash)
+
+	stored, err := p.indexer.Get(record.Path())
+	if err != nil {
+		return
+	}
+	defer p.indexer.Kill(stored)
+
+	var unchanged bool
+	if storedHash := stored.Field(HashField); storedHash != "" {
+		unchanged = hash == storedHash
+	} else if etag := record.Field(ETagField); etag != "" && stored.Field(ETagField) != "" {
+		unchanged = etag == stored.Field(ETagField)
+	} else if !record.Modified().IsZero() && stored.Modified().Unix() > 0 {
+		unchanged = record.Modified().Equal(stored.Modified())
+	}
+
+	if !unchanged {
+		return
+	}
+	p.ignore(record, IgnoredUnchanged)
+
+	// like for skipped static files, dedupe won't see it
+	if p.config.Duplicates == "" {
+		return
+	}
+	if fp, ok := parseFingerprint(stored.Field(FingerprintField)); ok {
+		p.Duplicates.Add(record.Path(), fp)
+	}
+}
+
+var (
+	titleTag    = []byte("title")
+	anchorTag   = []byte("a")
+	linkTag     = []byte("link")
+	metaTag     = []byte("meta")
+	hrefAttr    = []byte("href")
+	relAttr     = []byte("rel")
+	nameAttr    = []byte("name")
+	contentAttr = []byte("content")
+)
 
 // parse is the step of the pipeline that tries to parse documents and get
 // important information
 func (p *Pipeline) parse(in interface{}) interface{} {
 	if record, ok := in.(indexer.Record); ok && !record.Ignored() {
-		if strings.HasSuffix(record.Path(), ".txt") || strings.HasSuffix(record.Path(), ".md") {
-			// TODO: We can improve file type detection; this is a very limited subset of indexable file types
-			// text or markdown file
-			record.SetTitle(path.Base(record.Path()))
-		} else {
-			body := bytes.NewReader(record.Body())
-			title, err := getHTMLContent(body, titleTag)
-			if err == nil {
-				// html file
-				record.SetTitle(title)
-				stripped := bm.SanitizeBytes(record.Body())
-				record.SetBody(stripped)
-			} else {
-				record.Ignore()
-			}
+		mimeType := detectType(record)
+		record.SetField(MimeTypeField, mimeType)
+
+		extractor, ok := lookupExtractor(mimeType)
+		if !ok {
+			p.ignore(record, IgnoredUnsupported)
+			return in
+		}
+		if htmlTypes[mimeType] && !p.readHTML(record) {
+			return in
+		}
+
+		extracted, err := extractor.Extract(Content{
+			Path:     record.Path(),
+			MimeType: mimeType,
+			Body:     record.Body(),
+			Fields:   record.Fields(),
+		})
+		if err != nil {
+			p.ignore(record, IgnoredExtractError)
+			p.Stats.Error(err)
+			return in
 		}
+
+		p.apply(record, extracted)
 	}
 
 	return in
 }
 
-func getHTMLContent(r io.Reader, tag []byte) (result string, err error) {
+// htmlTypes are the MIME types of HTML documents
+var htmlTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+}
+
+// readHTML handles the robots meta tags and the links of an HTML document,
+// whichever extractor reads its content: it notes the links to follow and
+// stores a dynamic page under the path of its canonical link. It returns
+// false if the document mustn't be indexed.
+func (p *Pipeline) readHTML(record indexer.Record) bool {
+	content, err := getHTMLContent(bytes.NewReader(record.Body()))
+	if err != nil {
+		// the extractor tells what's wrong with it
+		return true
+	}
+
+	var links []string
+	if !content.NoFollow {
+		links = content.Links
+	}
+	p.noteLinks(record, links)
+
+	if content.NoIndex {
+		p.ignore(record, IgnoredRobots)
+		p.unindex(record.Path())
+		return false
+	}
+
+	if content.Canonical != "" && record.Field(SourceField) == SourceDynamic {
+		p.canonicalize(record, content.Canonical)
+	}
+	return true
+}
+
+// apply fills the record with what its extractor read
+func (p *Pipeline) apply(record indexer.Record, extracted *Extracted) {
+	title := extracted.Title
+	if title == "" {
+		title = pageName(record.Path())
+	}
+	record.SetTitle(title)
+
+	if extracted.Body != nil {
+		record.SetBody(extracted.Body)
+	}
+	for name, value := range extracted.Fields {
+		record.SetField(name, value)
+	}
+}
+
+// htmlContent is what is read from an HTML document. NoIndex and NoFollow
+// come from its robots meta tags.
+type htmlContent struct {
+	Title     string
+	Heading   string
+	Links     []string
+	Canonical string
+	NoIndex   bool
+	NoFollow  bool
+}
+
+// canonicalize stores the dynamic page under the path of its canonical link,
+// if it's another page of this site that can be indexed, and removes the copy
+// stored under the path it was requested at. Static documents and the
+// documents of other sites sharing the index are never replaced.
+func (p *Pipeline) canonicalize(record indexer.Record, href string) {
+	requested := record.Path()
+	canonical, ok := p.config.ResolvePath(requested, href)
+	if !ok || canonical == requested || !p.ValidatePath(canonical) || !p.replaceable(canonical) {
+		return
+	}
+
+	record.SetPath(canonical)
+	record.SetField(CanonicalField, requested)
+	p.unindex(requested)
+
+	if access, ok := p.config.Access(canonical); ok {
+		record.SetField(AccessField, access)
+	}
+}
+
+// replaceable returns true if a dynamic page may be stored at the path: it's
+// not indexed yet, or holds a dynamic page of this site
+func (p *Pipeline) replaceable(path string) bool {
+	record, err := p.indexer.Get(path)
+	if err != nil {
+		return true
+	}
+	defer p.indexer.Kill(record)
+
+	return record.Field(SourceField) == SourceDynamic && p.owns(record)
+}
+
+// getHTMLContent reads the title and the first heading of the document, the
+// targets of its links not marked nofollow, its canonical link and its
+// robots meta tags
+func getHTMLContent(r io.Reader) (content htmlContent, err error) {
 	z := html.NewTokenizer(r)
-	result = ""
-	valid := 0
-	cacheLen := len(tag)
+	inTitle, inHeading := false, false
 
 	for {
 		tt := z.Next()
 		switch tt {
 		case html.ErrorToken:
-			err = z.Err()
+			if err = z.Err(); err == io.EOF {
+				err = nil
+			}
 			return
 		case html.TextToken:
-			if valid == 1 {
-				return string(z.Text()), nil
+			text := strings.TrimSpace(string(z.Text()))
+			if inTitle && content.Title == "" {
+				content.Title = text
 			}
-		case html.StartTagToken, html.EndTagToken:
-			tn, _ := z.TagName()
-			if len(tn) == cacheLen && bytes.Equal(tn[0:cacheLen], tag) {
-				if tt == html.StartTagToken {
-					valid = 1
-				} else {
-					valid = 0
+			if inHeading && content.Heading == "" {
+				content.Heading = text
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			tn, hasAttr := z.TagName()
+			switch {
+			case bytes.Equal(tn, titleTag):
+				inTitle = tt == html.StartTagToken
+			case isHeading(tn):
+				inHeading = tt == html.StartTagToken
+			case bytes.Equal(tn, anchorTag) && tt != html.EndTagToken:
+				attrs := tagAttrs(z, hasAttr)
+				href, ok := attrs[string(hrefAttr)]
+				if ok && !hasRel(attrs[string(relAttr)], "nofollow") {
+					content.Links = append(content.Links, href)
+				}
+			case bytes.Equal(tn, linkTag) && tt != html.EndTagToken:
+				attrs := tagAttrs(z, hasAttr)
+				if content.Canonical == "" && hasRel(attrs[string(relAttr)], "canonical") {
+					content.Canonical = attrs[string(hrefAttr)]
+				}
+			case bytes.Equal(tn, metaTag) && tt != html.EndTagToken:
+				attrs := tagAttrs(z, hasAttr)
+				name := strings.ToLower(attrs[string(nameAttr)])
+				if name == "robots" || name == robotsAgent {
+					noindex, nofollow := robotsDirectives(attrs[string(contentAttr)])
+					content.NoIndex = content.NoIndex || noindex
+					content.NoFollow = content.NoFollow || nofollow
 				}
 			}
 		}
 	}
 }
 
+// isHeading returns true for the tags h1 to h6
+func isHeading(tag []byte) bool {
+	return len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6'
+}
+
+// hasRel returns true if the rel attribute holds the link type
+func hasRel(rel, linkType string) bool {
+	for _, t := range strings.Fields(strings.ToLower(rel)) {
+		if t == linkType {
+			return true
+		}
+	}
+	return false
+}
+
+// tagAttrs returns the attributes of the current tag
+func tagAttrs(z *html.Tokenizer, hasAttr bool) map[string]string {
+	attrs := make(map[string]string)
+	for hasAttr {
+		var key, val []byte
+		key, val, hasAttr = z.TagAttr()
+		attrs[string(key)] = string(val)
+	}
+	return attrs
+}
+
+// dedupe is the step of the pipeline that fingerprints documents and finds
+// near-duplicates of already indexed ones
+func (p *Pipeline) dedupe(in interface{}) interface{} {
+	if record, ok := in.(indexer.Record); ok && !record.Ignored() {
+		fp := SimHash(record.Body())
+		record.SetField(FingerprintField, formatFingerprint(fp))
+		record.SetField(DuplicateOfField, "")
+
+		if p.config.Duplicates == "" {
+			return in
+		}
+
+		original := p.Duplicates.Add(record.Path(), fp)
+		if original == "" {
+			return in
+		}
+
+		record.SetField(DuplicateOfField, original)
+		if p.config.Duplicates == DuplicatesSkip {
+			p.ignore(record, IgnoredDuplicate)
+		}
+	}
+
+	return in
+}
+
 // index is the step of the pipeline that pipes valid documents to the indexer.
+// The indexer owns the documents it receives, so only ignored documents go on
+// to the pipeline's output to be released.
+// The crawl state of a static file is kept once the indexer stored it, or
+// right away when the pipeline ignored it: reading it again would give the
+// same outcome until the file or the config change. Skipped duplicates are
+// the exception: they are read again by the next scans, to be indexed once
+// their original is gone.
 func (p *Pipeline) index(in interface{}) interface{} {
 	if record, ok := in.(indexer.Record); ok {
-		if !record.Ignored() {
-			p.indexer.Pipe(record)
+		p.release(record)
+
+		static := p.Crawl != nil && record.FullPath() != ""
+		if record.Ignored() {
+			if static && record.Field(DuplicateOfField) != "" {
+				p.Crawl.Remove(record.Path())
+			} else if static {
+				p.Crawl.Commit(record.Path())
+			}
+			return in
 		}
+
+		path := record.Path()
+		dynamic := p.Indexed != nil && record.Field(SourceField) == SourceDynamic && p.owns(record)
+		atomic.AddInt64(&p.indexing, 1)
+		record.OnIndexed(func(err error) {
+			atomic.AddInt64(&p.indexing, -1)
+			if dynamic && err == nil {
+				p.Indexed(path)
+			}
+			if !static {
+				return
+			}
+			if err != nil {
+				p.Crawl.Discard(path)
+			} else {
+				p.Crawl.Commit(path)
+			}
+		})
+		p.Stats.Indexed()
+		p.indexer.Pipe(record)
+		return nil
 	}
 	return in
 }
@@ -174,6 +610,10 @@ func (p *Pipeline) ValidatePath(path string) bool {
 		}
 	}
 
+	if !p.config.Robots.Allowed(path) {
+		return false
+	}
+
 	for _, pa := range p.config.IncludePaths {
 		if pa.MatchString(path) {
 			return true
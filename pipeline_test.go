@@ -1,11 +1,17 @@
 package search_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mholt/caddy/caddyhttp/httpserver"
 	"github.com/pedronasser/caddy-search"
 	"github.com/pedronasser/caddy-search/indexer/bleve"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func BenchmarkPipeline(b *testing.B) {
@@ -32,3 +38,99 @@ func BenchmarkPipeline(b *testing.B) {
 		pipeline.Pipe(rec)
 	}
 }
+
+func TestUnchanged(t *testing.T) {
+	Convey("Given an indexed dynamic page", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		body, header := "<title>Page</title><p>First version</p>", http.Header{}
+		ignored := func() int64 {
+			_, n := site.pipeline.Stats.Totals()
+			return n
+		}
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(body))
+			return http.StatusOK, nil
+		})
+		// serve requests the page and waits for it to be indexed or ignored
+		serve := func() {
+			indexed, ignored := site.pipeline.Stats.Totals()
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+			eventually(func() bool {
+				i, n := site.pipeline.Stats.Totals()
+				return i+n > indexed+ignored
+			})
+			site.settle()
+		}
+		indexedBody := func() string {
+			record, err := site.index.Get("/page")
+			if err != nil {
+				return ""
+			}
+			defer site.index.Kill(record)
+			return string(record.Body())
+		}
+
+		Convey("When it was indexed with a hash", func() {
+			header.Set("ETag", `"v1"`)
+			header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			serve()
+			So(eventually(func() bool { return site.indexed("/page") }), ShouldBeTrue)
+
+			Convey("Should skip the same content", func() {
+				header.Set("ETag", `"v2"`)
+				serve()
+				So(ignored(), ShouldEqual, 1)
+			})
+
+			Convey("Should index changed content despite the same validators", func() {
+				body = "<title>Page</title><p>Second version</p>"
+				serve()
+				So(ignored(), ShouldEqual, 0)
+				So(eventually(func() bool { return strings.Contains(indexedBody(), "Second version") }), ShouldBeTrue)
+			})
+		})
+
+		Convey("When it was indexed without a hash", func() {
+			record := site.index.Record("/page")
+			record.SetField(search.SourceField, search.SourceDynamic)
+			record.SetField(search.ETagField, `"v1"`)
+			record.SetModified(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC))
+			record.SetBody([]byte("First version"))
+			site.index.Pipe(record)
+			site.settle()
+			So(eventually(func() bool { return site.indexed("/page") }), ShouldBeTrue)
+
+			body = "<title>Page</title><p>Second version</p>"
+
+			Convey("Should skip the page with the same ETag", func() {
+				header.Set("ETag", `"v1"`)
+				serve()
+				So(ignored(), ShouldEqual, 1)
+			})
+
+			Convey("Should index the page with another ETag", func() {
+				header.Set("ETag", `"v2"`)
+				serve()
+				So(ignored(), ShouldEqual, 0)
+			})
+
+			Convey("Should skip the page with the same Last-Modified time", func() {
+				header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+				serve()
+				So(ignored(), ShouldEqual, 1)
+			})
+
+			Convey("Should index the page modified since", func() {
+				header.Set("Last-Modified", "Tue, 03 Jan 2006 15:04:05 GMT")
+				serve()
+				So(ignored(), ShouldEqual, 0)
+			})
+		})
+	})
+}
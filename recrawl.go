@@ -0,0 +1,262 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// recrawlTick is how often the recrawler looks for expired pages
+var recrawlTick = 5 * time.Second
+
+// recrawlAgent is the User-Agent of the recrawler's internal requests
+const recrawlAgent = "caddy-search"
+
+// maxRecrawlRate bounds the rate of internal requests, in requests per second
+const maxRecrawlRate = 1000
+
+// requestInterval returns the interval between internal requests sent at the
+// rate, in requests per second. Rates out of (0, maxRecrawlRate] are clamped.
+func requestInterval(rate float64) time.Duration {
+	if rate <= 0 {
+		rate = 1
+	}
+	if rate > maxRecrawlRate {
+		rate = maxRecrawlRate
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// Recrawler refreshes the dynamic pages whose indexed copy is older than
+// Config.Expire, by requesting them again through the rest of the handler
+// chain. The pages are tracked as they are indexed, and from the index at
+// startup.
+type Recrawler struct {
+	search      *Search
+	expire      time.Duration
+	concurrency int
+	interval    time.Duration
+
+	mutex sync.Mutex
+	due   map[string]time.Time
+	queue chan string
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewRecrawler creates the recrawler of the site. It runs up to concurrency
+// requests at once and at most rate requests per second.
+func NewRecrawler(s *Search, concurrency int, rate float64) *Recrawler {
+	return &Recrawler{
+		search:      s,
+		expire:      s.Config.Expire,
+		concurrency: concurrency,
+		interval:    requestInterval(rate),
+		due:         make(map[string]time.Time),
+		queue:       make(chan string, concurrency),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start tracks the dynamic pages already indexed and starts refreshing them
+func (rc *Recrawler) Start() {
+	limiter := time.NewTicker(rc.interval)
+	for i := 0; i < rc.concurrency; i++ {
+		go rc.work(limiter.C)
+	}
+
+	go func() {
+		defer limiter.Stop()
+		rc.seed()
+		rc.loop()
+	}()
+}
+
+// Close stops refreshing pages. Requests already running complete.
+func (rc *Recrawler) Close() {
+	rc.once.Do(func() {
+		close(rc.done)
+	})
+}
+
+// Track schedules the page indexed at the given time to be refreshed when it
+// expires
+func (rc *Recrawler) Track(path string, indexed time.Time) {
+	rc.mutex.Lock()
+	rc.due[path] = indexed.Add(rc.expire)
+	rc.mutex.Unlock()
+}
+
+// Forget stops refreshing the page
+func (rc *Recrawler) Forget(path string) {
+	rc.mutex.Lock()
+	delete(rc.due, path)
+	rc.mutex.Unlock()
+}
+
+// seed tracks the dynamic pages this site indexed
+func (rc *Recrawler) seed() {
+	index := rc.search.Indexer
+	for offset := 0; ; offset += maxListLimit {
+		records, err := index.List(offset, maxListLimit)
+		if err != nil {
+			rc.search.Pipeline.Stats.Error(err)
+			return
+		}
+		for _, record := range records {
+			if record.Field(SourceField) == SourceDynamic && rc.search.Pipeline.owns(record) {
+				rc.Track(record.Path(), record.Indexed())
+			}
+			index.Kill(record)
+		}
+		if len(records) < maxListLimit {
+			return
+		}
+	}
+}
+
+func (rc *Recrawler) loop() {
+	tick := time.NewTicker(recrawlTick)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			rc.enqueue()
+		case <-rc.done:
+			return
+		}
+	}
+}
+
+// enqueue queues the expired pages. Pages that don't fit in the queue stay
+// due for the next tick.
+func (rc *Recrawler) enqueue() {
+	now := time.Now()
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for path, due := range rc.due {
+		if due.After(now) {
+			continue
+		}
+		select {
+		case rc.queue <- path:
+			// pushed back until the refreshed copy tracks it again
+			rc.due[path] = now.Add(rc.expire)
+		default:
+			return
+		}
+	}
+}
+
+func (rc *Recrawler) work(limiter <-chan time.Time) {
+	for {
+		select {
+		case path := <-rc.queue:
+			select {
+			case <-limiter:
+				rc.fetch(path)
+			case <-rc.done:
+				return
+			}
+		case <-rc.done:
+			return
+		}
+	}
+}
+
+// fetch requests the page again if its indexed copy is still expired
+func (rc *Recrawler) fetch(path string) {
+	record, err := rc.search.Indexer.Get(path)
+	if err == indexer.ErrNotFound {
+		rc.Forget(path)
+		return
+	}
+	if err != nil {
+		rc.search.Pipeline.Stats.Error(err)
+		return
+	}
+	indexed := record.Indexed()
+	rc.search.Indexer.Kill(record)
+
+	// a visit refreshed it meanwhile
+	if time.Since(indexed) < rc.expire {
+		rc.Track(path, indexed)
+		return
+	}
+
+	r, err := rc.search.internalRequest(path)
+	if err != nil {
+		rc.Forget(path)
+		return
+	}
+
+	rc.search.capture(newInternalResponseWriter(false), r)
+}
+
+// internalRequest creates a GET request of the path sent by the search
+// itself through the rest of the handler chain
+func (s *Search) internalRequest(path string) (*http.Request, error) {
+	r, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Host = s.Config.Host
+	r.RemoteAddr = "127.0.0.1:0"
+	r.RequestURI = path
+	r.Header.Set("User-Agent", recrawlAgent)
+
+	ctx := context.WithValue(r.Context(), httpserver.OriginalURLCtxKey, *r.URL)
+	return r.WithContext(ctx), nil
+}
+
+// internalResponseWriter is the response writer of internal requests. The
+// body is kept if it's needed, otherwise it's only captured for the index.
+// Kept bodies are only read as sitemaps: they are cut one byte past
+// sitemapLimit, enough to tell that they're too large.
+type internalResponseWriter struct {
+	header http.Header
+	status int
+	body   *bytes.Buffer
+}
+
+func newInternalResponseWriter(keepBody bool) *internalResponseWriter {
+	w := &internalResponseWriter{header: make(http.Header)}
+	if keepBody {
+		w.body = new(bytes.Buffer)
+	}
+	return w
+}
+
+func (w *internalResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *internalResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *internalResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.body != nil {
+		if room := sitemapLimit + 1 - w.body.Len(); room < len(p) {
+			if room > 0 {
+				w.body.Write(p[:room])
+			}
+			return len(p), nil
+		}
+		return w.body.Write(p)
+	}
+	return len(p), nil
+}
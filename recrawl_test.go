@@ -0,0 +1,71 @@
+package search_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexedPages(t *testing.T) {
+	Convey("Given a site tracking its indexed dynamic pages", t, func() {
+		site := newTestSite(nil)
+		Reset(site.close)
+
+		site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			if r.URL.Path == "/missing" {
+				return http.StatusNotFound, nil
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<title>Page</title><p>Content of " + r.URL.Path + "</p>"))
+			return http.StatusOK, nil
+		})
+
+		var mutex sync.Mutex
+		var tracked []string
+		site.pipeline.Indexed = func(path string) {
+			mutex.Lock()
+			tracked = append(tracked, path)
+			mutex.Unlock()
+		}
+		serve := func(path string) {
+			site.search.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+
+		trackedPaths := func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]string(nil), tracked...)
+		}
+
+		serve("/page")
+		So(eventually(func() bool { return len(trackedPaths()) == 1 }), ShouldBeTrue)
+
+		Convey("Should only track the pages once they're indexed", func() {
+			serve("/missing")
+			serve("/page")
+			So(eventually(func() bool {
+				_, ignored := site.pipeline.Stats.Totals()
+				return ignored == 2
+			}), ShouldBeTrue)
+			site.settle()
+
+			So(trackedPaths(), ShouldResemble, []string{"/page"})
+		})
+
+		Convey("Should not track static files", func() {
+			record := site.index.Record("/static.html")
+			record.SetField(search.SourceField, search.SourceStatic)
+			record.SetBody([]byte("Static file"))
+			site.pipeline.Pipe(record)
+			site.settle()
+			So(eventually(func() bool { return site.indexed("/static.html") }), ShouldBeTrue)
+
+			So(trackedPaths(), ShouldResemble, []string{"/page"})
+		})
+	})
+}
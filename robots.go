@@ -0,0 +1,168 @@
+package search
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// robotsAgent is the name robots rules can address the search by
+const robotsAgent = recrawlAgent
+
+// robotsValued are the robots directives that take a value, which a
+// "directive:" prefix doesn't name a user agent with
+var robotsValued = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// robotsDirectives reads the directives of a robots meta tag or of an
+// X-Robots-Tag header value. Values addressed to other user agents with a
+// leading "agent:" prefix are skipped.
+func robotsDirectives(value string) (noindex, nofollow bool) {
+	directives := strings.Split(strings.ToLower(value), ",")
+	if i := strings.Index(directives[0], ":"); i >= 0 {
+		agent := strings.TrimSpace(directives[0][:i])
+		if !robotsValued[agent] {
+			if agent != robotsAgent {
+				return false, false
+			}
+			directives[0] = directives[0][i+1:]
+		}
+	}
+
+	for _, directive := range directives {
+		switch strings.TrimSpace(directive) {
+		case "noindex":
+			noindex = true
+		case "nofollow":
+			nofollow = true
+		case "none":
+			noindex, nofollow = true, true
+		}
+	}
+	return
+}
+
+// robotsHeader reads the X-Robots-Tag headers of a response
+func robotsHeader(header http.Header) (noindex, nofollow bool) {
+	for _, value := range header["X-Robots-Tag"] {
+		ni, nf := robotsDirectives(value)
+		noindex = noindex || ni
+		nofollow = nofollow || nf
+	}
+	return
+}
+
+// Robots are the Allow and Disallow rules of a robots.txt that apply to an
+// agent
+type Robots struct {
+	rules []robotsRule
+}
+
+// robotsRule is an Allow or Disallow rule with the paths it matches
+type robotsRule struct {
+	path  string
+	allow bool
+	exp   *regexp.Regexp
+}
+
+// ParseRobots returns the rules of a robots.txt that apply to the agent: the
+// ones of its own group, or else of the `*` group
+func ParseRobots(r io.Reader, agent string) *Robots {
+	agent = strings.ToLower(agent)
+	groups := make(map[string][]robotsRule)
+
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch field {
+		case "user-agent":
+			// a user-agent line after rules starts a new group
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+			for _, a := range agents {
+				if _, ok := groups[a]; !ok {
+					groups[a] = []robotsRule{}
+				}
+			}
+		case "disallow", "allow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{
+				path:  value,
+				allow: field == "allow",
+				exp:   regexp.MustCompile(RobotsRule(value)),
+			}
+			for _, a := range agents {
+				groups[a] = append(groups[a], rule)
+			}
+		}
+	}
+
+	if rules, ok := groups[agent]; ok {
+		return &Robots{rules: rules}
+	}
+	return &Robots{rules: groups["*"]}
+}
+
+// Allowed returns true if the path may be indexed. The longest rule matching
+// the path decides, Allow winning over a Disallow rule as long; paths no rule
+// matches are allowed.
+func (r *Robots) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+
+	allowed, length := true, -1
+	for _, rule := range r.rules {
+		if !rule.exp.MatchString(path) {
+			continue
+		}
+		if n := len(rule.path); n > length || n == length && rule.allow {
+			allowed, length = rule.allow, n
+		}
+	}
+	return allowed
+}
+
+// RobotsRule converts a robots.txt path rule to a regular expression of the
+// paths it matches: rules are path prefixes where `*` matches any sequence
+// and a final `$` anchors the end
+func RobotsRule(rule string) string {
+	anchored := strings.HasSuffix(rule, "$")
+	rule = strings.TrimSuffix(rule, "$")
+
+	parts := strings.Split(rule, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	exp := "^" + strings.Join(parts, ".*")
+	if anchored {
+		exp += "$"
+	}
+	return exp
+}
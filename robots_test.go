@@ -0,0 +1,100 @@
+package search_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const robotsTxt = `# comments are ignored
+User-agent: Googlebot
+Disallow: /nogoogle
+
+User-agent: *
+Disallow: /private/
+Disallow: /*.pdf$
+Allow: /private/public
+Disallow:
+
+User-agent: caddy-search
+User-agent: other
+Disallow: /drafts
+`
+
+func TestRobots(t *testing.T) {
+	Convey("Given a robots.txt", t, func() {
+		Convey("Should use the group of the agent", func() {
+			robots := search.ParseRobots(strings.NewReader(robotsTxt), "caddy-search")
+			So(robots.Allowed("/drafts/page.html"), ShouldBeFalse)
+			So(robots.Allowed("/private/page.html"), ShouldBeTrue)
+			So(robots.Allowed("/nogoogle"), ShouldBeTrue)
+		})
+
+		Convey("Should fall back to the `*` group", func() {
+			robots := search.ParseRobots(strings.NewReader(robotsTxt), "unknown")
+			So(robots.Allowed("/private/page.html"), ShouldBeFalse)
+			So(robots.Allowed("/docs/manual.pdf"), ShouldBeFalse)
+			So(robots.Allowed("/drafts/page.html"), ShouldBeTrue)
+		})
+
+		Convey("Should let the longest matching rule decide", func() {
+			robots := search.ParseRobots(strings.NewReader(robotsTxt), "unknown")
+			So(robots.Allowed("/private/public"), ShouldBeTrue)
+			So(robots.Allowed("/private/public/page.html"), ShouldBeTrue)
+			So(robots.Allowed("/private/publication"), ShouldBeTrue)
+			So(robots.Allowed("/private/public/manual.pdf"), ShouldBeTrue)
+		})
+
+		Convey("Should prefer Allow over a Disallow rule as long", func() {
+			robots := search.ParseRobots(strings.NewReader("User-agent: *\nDisallow: /page\nAllow: /page\n"), "unknown")
+			So(robots.Allowed("/page"), ShouldBeTrue)
+		})
+
+		Convey("Should allow everything without rules", func() {
+			var robots *search.Robots
+			So(robots.Allowed("/private/page.html"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given robots.txt rules", t, func() {
+		Convey("Should match path prefixes", func() {
+			rule := regexp.MustCompile(search.RobotsRule("/private/"))
+			So(rule.MatchString("/private/page.html"), ShouldBeTrue)
+			So(rule.MatchString("/public/private/"), ShouldBeFalse)
+		})
+
+		Convey("Should support wildcards and end anchors", func() {
+			rule := regexp.MustCompile(search.RobotsRule("/*.pdf$"))
+			So(rule.MatchString("/docs/manual.pdf"), ShouldBeTrue)
+			So(rule.MatchString("/docs/manual.pdf.html"), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given robots meta tags and X-Robots-Tag headers", t, func() {
+		directives := func(value string) [2]bool {
+			noindex, nofollow := search.RobotsDirectives(value)
+			return [2]bool{noindex, nofollow}
+		}
+
+		Convey("Should read the directives", func() {
+			So(directives("noindex"), ShouldResemble, [2]bool{true, false})
+			So(directives("NoIndex, NoFollow"), ShouldResemble, [2]bool{true, true})
+			So(directives("none"), ShouldResemble, [2]bool{true, true})
+			So(directives("index, follow"), ShouldResemble, [2]bool{false, false})
+		})
+
+		Convey("Should only apply the directives addressed to the search", func() {
+			So(directives("caddy-search: noindex"), ShouldResemble, [2]bool{true, false})
+			So(directives("googlebot: noindex, nofollow"), ShouldResemble, [2]bool{false, false})
+		})
+
+		Convey("Should not take directives with values for user agents", func() {
+			So(directives("noindex, unavailable_after: 25 Jun 2030 15:00:00 PST"), ShouldResemble, [2]bool{true, false})
+			So(directives("unavailable_after: 25 Jun 2030 15:00:00 PST, nofollow"), ShouldResemble, [2]bool{false, true})
+			So(directives("max-snippet: 20, noindex"), ShouldResemble, [2]bool{true, false})
+		})
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mholt/caddy/caddyhttp/httpserver"
@@ -16,10 +18,42 @@ type Search struct {
 	*Config
 	Indexer indexer.Handler
 	*Pipeline
+
+	stop      chan struct{}
+	stopOnce  sync.Once
+	watcher   *Watcher
+	recrawler *Recrawler
+}
+
+// Stop stops the background scans, the watcher and the recrawler and drains
+// the pipeline.
+// The index is left open since other sites or a reloaded instance may share
+// it.
+func (s *Search) Stop() {
+	s.stopOnce.Do(func() {
+		if s.stop != nil {
+			close(s.stop)
+		}
+		if s.watcher != nil {
+			s.watcher.Close()
+		}
+		if s.recrawler != nil {
+			s.recrawler.Close()
+		}
+		s.Pipeline.Close()
+	})
 }
 
 // ServerHTTP is the HTTP handler for this middleware
 func (s *Search) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+	if s.Config.MetricsEndpoint != "" && httpserver.Path(r.URL.Path).Matches(s.Config.MetricsEndpoint) {
+		return s.ServeMetrics(w, r)
+	}
+
+	if s.Config.AdminEndpoint != "" && httpserver.Path(r.URL.Path).Matches(s.Config.AdminEndpoint) {
+		return s.ServeAdmin(w, r)
+	}
+
 	if httpserver.Path(r.URL.Path).Matches(s.Config.Endpoint) {
 		if r.Header.Get("Accept") == "application/json" || s.Config.Template == nil {
 			return s.SearchJSON(w, r)
@@ -27,53 +61,279 @@ func (s *Search) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 		return s.SearchHTML(w, r)
 	}
 
-	record := s.Indexer.Record(r.URL.String())
+	return s.capture(w, r)
+}
+
+// capture serves the request through the rest of the chain and pipes the
+// response to be indexed
+func (s *Search) capture(w http.ResponseWriter, r *http.Request) (int, error) {
+	record, status, err := s.captureRecord(w, r)
+	go s.Pipeline.Pipe(record)
+	return status, err
+}
+
+// captureRecord serves the request through the rest of the chain and returns
+// the record of the response, to be piped
+func (s *Search) captureRecord(w http.ResponseWriter, r *http.Request) (indexer.Record, int, error) {
+	record := s.Indexer.Record(s.Config.NormalizeURL(r.URL))
+	record.SetField(SourceField, SourceDynamic)
+
+	// content served to an authenticated user is only shown to that user
+	// unless a visibility rule says otherwise
+	if user := RemoteUser(r); user != "" {
+		record.SetField(AccessField, user)
+	}
 
-	status, err := s.Next.ServeHTTP(&searchResponseWriter{w, record}, r)
+	rw := &searchResponseWriter{w: w, record: record}
+	status, err := s.Next.ServeHTTP(rw, r)
 
 	modif := w.Header().Get("Last-Modified")
 	if len(modif) > 0 {
-		modTime, err := time.Parse(`Mon, 2 Jan 2006 15:04:05 MST`, modif)
+		modTime, err := http.ParseTime(modif)
 		if err == nil {
 			record.SetModified(modTime)
 		}
 	}
 
-	if status != http.StatusOK {
+	if etag := w.Header().Get("ETag"); etag != "" {
+		record.SetField(ETagField, etag)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "" {
+		record.SetField(MimeTypeField, mediaType(contentType))
+	}
+
+	noindex, _ := robotsHeader(w.Header())
+	if status != http.StatusOK || record.Ignored() {
 		record.Ignore()
+		s.Pipeline.Stats.Ignored(IgnoredStatus)
+	} else if noindex {
+		s.Pipeline.ignore(record, IgnoredRobots)
 	}
 
-	go s.Pipeline.Pipe(record)
+	// the page is gone or mustn't be indexed anymore: handlers either write
+	// the error response themselves or return its status
+	if gone(status) || gone(rw.status) || noindex {
+		go s.Pipeline.removeGone(record.Path(), RemoteUser(r))
+		if s.recrawler != nil {
+			s.recrawler.Forget(record.Path())
+		}
+	}
 
-	return status, err
+	return record, status, err
 }
 
 // Result is the structure for the search result
 type Result struct {
-	Path     string
-	Title    string
-	Body     string
-	Modified time.Time
-	Indexed  time.Time
+	Path      string
+	URL       string
+	Host      string
+	Title     string
+	Body      string
+	Modified  time.Time
+	Indexed   time.Time
+	Promoted  bool
+	Collapsed int
+	Score     float64
 }
 
-// SearchJSON renders the search results in JSON format
-func (s *Search) SearchJSON(w http.ResponseWriter, r *http.Request) (int, error) {
-	q := r.URL.Query().Get("q")
-	indexResult := s.Indexer.Search(q)
+// resultSize is the number of results returned for a query
+const resultSize = 10
+
+// overfetchFactor is how many more hits are fetched when results are
+// collapsed or filtered so that a full page can still be shown
+const overfetchFactor = 5
+
+// Query builds the indexer query for the request. The `mode` and `operator`
+// parameters override the configured defaults.
+func (s *Search) Query(r *http.Request) indexer.Query {
+	v := r.URL.Query()
+	q := indexer.Query{
+		Text:     v.Get("q"),
+		Mode:     s.Config.Mode,
+		Operator: s.Config.Operator,
+		Size:     resultSize,
+	}
+
+	switch mode := v.Get("mode"); mode {
+	case indexer.SimpleMode, indexer.AdvancedMode:
+		q.Mode = mode
+	}
+
+	switch op := strings.ToLower(v.Get("operator")); op {
+	case indexer.OperatorAnd, indexer.OperatorOr:
+		q.Operator = op
+	}
+
+	return q
+}
+
+// collapseMode returns the collapse mode for the request. The `collapse`
+// parameter overrides the configured default, `none` disables it.
+func (s *Search) collapseMode(r *http.Request) string {
+	switch mode := r.URL.Query().Get("collapse"); mode {
+	case CollapseURL, CollapseDir:
+		return mode
+	case "none":
+		return ""
+	}
+	return s.Config.Collapse
+}
+
+// search runs the query for the request and returns its results, pinned
+// results first. Results the requesting user can't see are left out.
+// A query parse error is reported with a 400 status.
+func (s *Search) search(q indexer.Query, r *http.Request) ([]Result, int, error) {
+	collapse := s.collapseMode(r)
+	user := RemoteUser(r)
+
+	size := q.Size
+	if collapse != "" || len(s.Config.AccessRules) > 0 {
+		q.Size = size * overfetchFactor
+	}
+
+	hits, err := s.searchSites(q)
+	if err != nil {
+		if _, ok := err.(*indexer.QueryError); ok {
+			return nil, http.StatusBadRequest, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	results := append([]Result{}, s.pinned(q.Text, user)...)
+	promoted := make(map[string]bool, len(results))
+	for _, result := range results {
+		promoted[result.Path] = true
+	}
+
+	for _, hit := range hits {
+		local := hit.host == "" || hit.host == s.Config.Host
+		if (local && promoted[hit.Path()]) || hit.Field(DuplicateOfField) != "" {
+			continue
+		}
+		if !Visible(s.access(hit), user) {
+			continue
+		}
+
+		result := Result{
+			Path:     hit.Path(),
+			URL:      hit.Path(),
+			Host:     hit.host,
+			Title:    hit.Title(),
+			Modified: hit.Modified(),
+			Indexed:  hit.Indexed(),
+			Body:     string(hit.Body()),
+			Score:    hit.score,
+		}
+		if !local {
+			result.URL = "//" + hit.host + hit.Path()
+		}
+		results = append(results, result)
+	}
+
+	if collapse != "" {
+		results = Collapse(results, collapse, s.Config.CollapseDepth)
+	}
+	if max := len(promoted) + size; len(results) > max {
+		results = results[:max]
+	}
 
-	results := make([]Result, len(indexResult))
+	return results, http.StatusOK, nil
+}
 
-	for i, result := range indexResult {
-		body := result.Body()
-		results[i] = Result{
-			Path:     result.Path(),
-			Title:    result.Title(),
-			Modified: result.Modified(),
-			Indexed:  result.Indexed(),
-			Body:     string(body),
+// access returns the users allowed to see the hit. The visibility rules of
+// its site win over the access stored when it was indexed, so that rule
+// changes apply to documents that weren't indexed again since.
+func (s *Search) access(h hit) string {
+	config := s.Config
+	if h.host != "" && h.host != s.Config.Host {
+		if site := lookupSite(h.host); site != nil {
+			config = site.Config
 		}
 	}
+	if access, ok := config.Access(h.Path()); ok {
+		return access
+	}
+	return h.Field(AccessField)
+}
+
+// pinned returns the promoted results for the query. Pins without a title or
+// description take them from the indexed document.
+func (s *Search) pinned(q string, user string) (results []Result) {
+	if s.Config.Pinned == nil {
+		return
+	}
+
+	if err := s.Config.Pinned.Reload(); err != nil {
+		s.Pipeline.Stats.Error(err)
+	}
+
+	for _, pin := range s.Config.Pinned.Match(q) {
+		result := Result{
+			Path:     pin.Path,
+			URL:      pin.Path,
+			Title:    pin.Title,
+			Body:     pin.Description,
+			Promoted: true,
+		}
+
+		access, ruled := s.Config.Access(pin.Path)
+		record := s.Indexer.Record(pin.Path)
+		loaded := record.Load()
+		if loaded && !ruled {
+			access = record.Field(AccessField)
+		}
+		if !Visible(access, user) {
+			s.Indexer.Kill(record)
+			continue
+		}
+
+		if loaded {
+			if result.Title == "" {
+				result.Title = record.Title()
+			}
+			if result.Body == "" {
+				result.Body = snippet(string(record.Body()), snippetLength)
+			}
+			result.Modified = record.Modified()
+			result.Indexed = record.Indexed()
+		}
+		s.Indexer.Kill(record)
+
+		if result.Title == "" {
+			result.Title = pin.Path
+		}
+
+		results = append(results, result)
+	}
+
+	return
+}
+
+// snippetLength is the length of the body shown for pins without description
+const snippetLength = 200
+
+// snippet cuts the text to at most n runes, at a word boundary when possible
+func snippet(text string, n int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// SearchJSON renders the search results in JSON format
+func (s *Search) SearchJSON(w http.ResponseWriter, r *http.Request) (int, error) {
+	q := s.Query(r)
+	start := time.Now()
+	results, status, err := s.search(q, r)
+	if err != nil {
+		return status, err
+	}
+	s.Pipeline.Stats.Query("json", time.Since(start), len(results))
 
 	jresp, err := json.Marshal(results)
 	if err != nil {
@@ -86,20 +346,14 @@ func (s *Search) SearchJSON(w http.ResponseWriter, r *http.Request) (int, error)
 
 // SearchHTML renders the search results in the HTML template
 func (s *Search) SearchHTML(w http.ResponseWriter, r *http.Request) (int, error) {
-	q := r.URL.Query().Get("q")
-
-	indexResult := s.Indexer.Search(q)
+	q := s.Query(r)
 
-	results := make([]Result, len(indexResult))
-
-	for i, result := range indexResult {
-		results[i] = Result{
-			Path:     result.Path(),
-			Title:    result.Title(),
-			Modified: result.Modified(),
-			Body:     string(result.Body()),
-		}
+	start := time.Now()
+	results, status, err := s.search(q, r)
+	if err != nil {
+		return status, err
 	}
+	s.Pipeline.Stats.Query("html", time.Since(start), len(results))
 
 	qresults := QueryResults{
 		Context: httpserver.Context{
@@ -107,12 +361,12 @@ func (s *Search) SearchHTML(w http.ResponseWriter, r *http.Request) (int, error)
 			Req:  r,
 			URL:  r.URL,
 		},
-		Query:   q,
+		Query:   q.Text,
 		Results: results,
 	}
 
 	var buf bytes.Buffer
-	err := s.Config.Template.Execute(&buf, qresults)
+	err = s.Config.Template.Execute(&buf, qresults)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -128,9 +382,16 @@ type QueryResults struct {
 	Results []Result
 }
 
+// gone returns true for the response statuses removing the page from the
+// index
+func gone(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone
+}
+
 type searchResponseWriter struct {
 	w      http.ResponseWriter
 	record indexer.Record
+	status int
 }
 
 func (r *searchResponseWriter) Header() http.Header {
@@ -138,6 +399,7 @@ func (r *searchResponseWriter) Header() http.Header {
 }
 
 func (r *searchResponseWriter) WriteHeader(code int) {
+	r.status = code
 	if code != http.StatusOK {
 		r.record.Ignore()
 	}
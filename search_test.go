@@ -1,6 +1,167 @@
 package search_test
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+	"github.com/pedronasser/caddy-search/indexer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSite is a site with its own index in a temporary directory
+type testSite struct {
+	dir         string
+	root        string
+	config      *search.Config
+	indexConfig indexer.Config
+	index       indexer.Handler
+	pipeline    *search.Pipeline
+	search      *search.Search
+}
+
+// newTestSite creates a site serving the files, by path relative to its root
+func newTestSite(files map[string]string) *testSite {
+	dir, _ := ioutil.TempDir("", "caddySearchTest")
+	root := filepath.Join(dir, "root")
+	for name, content := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		ioutil.WriteFile(file, []byte(content), 0644)
+	}
+	os.MkdirAll(root, 0755)
+
+	config := search.NewConfig("example.com", root)
+	config.IndexDirectory = filepath.Join(dir, "index")
+	config.IncludePaths = search.ConvertToRegExp([]string{"^/"})
+	indexConfig := indexer.Config{HostName: config.HostName, IndexDirectory: config.IndexDirectory}
+
+	index, err := search.OpenIndex(config.Engine, indexConfig)
+	So(err, ShouldBeNil)
+	pipeline, err := search.NewPipeline(config, index)
+	So(err, ShouldBeNil)
+	pipeline.Crawl = search.LoadCrawlState(config.CrawlStateFile(), config.Fingerprint())
+
+	return &testSite{
+		dir:         dir,
+		root:        root,
+		config:      config,
+		indexConfig: indexConfig,
+		index:       index,
+		pipeline:    pipeline,
+		search:      &search.Search{Config: config, Indexer: index, Pipeline: pipeline},
+	}
+}
+
+// settle waits for the queued documents to be indexed
+func (site *testSite) settle() {
+	site.pipeline.Wait(5 * time.Second)
+	for site.index.Status().Queued > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// indexed returns true if the path is in the index
+func (site *testSite) indexed(path string) bool {
+	record, err := site.index.Get(path)
+	if err != nil {
+		return false
+	}
+	site.index.Kill(record)
+	return true
+}
+
+// eventually polls the condition until it holds or a few seconds passed
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
+
+func (site *testSite) close() {
+	site.search.Stop()
+	search.ReleaseIndex(site.indexConfig)
+	os.RemoveAll(site.dir)
+}
+
+// fakeSite stands for the rest of the handler chain of a site, and keeps the
+// paths requested. It serves its HTML pages by path and answers the other
+// paths with a 404; without pages, every path gets a page whose content
+// changes at every request.
+type fakeSite struct {
+	pages     map[string]string
+	mutex     sync.Mutex
+	requested []string
+}
+
+func (f *fakeSite) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+	f.mutex.Lock()
+	f.requested = append(f.requested, r.URL.Path)
+	n := len(f.requested)
+	f.mutex.Unlock()
+
+	page, ok := f.pages[r.URL.Path]
+	if f.pages == nil {
+		page, ok = fmt.Sprintf("<title>%s</title><p>Version %d</p>", r.URL.Path, n), true
+	}
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(page))
+	return http.StatusOK, nil
+}
+
+func (f *fakeSite) paths() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.requested...)
+}
+
+func TestStop(t *testing.T) {
+	Convey("Given a site with documents queued for indexing", t, func() {
+		site := newTestSite(map[string]string{
+			"a.html": "<title>A</title><p>First page</p>",
+			"b.html": "<title>B</title><p>Second page</p>",
+		})
+		Reset(site.close)
+
+		search.ScanToPipe(site.root, site.pipeline, site.index)
+		site.search.Stop()
+
+		Convey("Should drain the pipeline and save the crawl state", func() {
+			So(site.pipeline.Stats.Queued(), ShouldEqual, 0)
+			_, err := os.Stat(site.config.CrawlStateFile())
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should drop the documents piped after it", func() {
+			site.pipeline.Pipe(site.index.Record("/late.html"))
+			So(site.pipeline.Stats.Queued(), ShouldEqual, 0)
+		})
+
+		Convey("Should leave the index to be closed by its last release", func() {
+			So(search.ReleaseIndex(site.indexConfig), ShouldBeNil)
+
+			reopened, err := search.OpenIndex(site.config.Engine, site.indexConfig)
+			So(err, ShouldBeNil)
+			count, err := reopened.Count()
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		})
+	})
+}
 
 func BenchmarkSearch(b *testing.B) {
 }
@@ -1,13 +1,14 @@
 package search
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"html/template"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mholt/caddy"
@@ -33,10 +34,15 @@ func Setup(c *caddy.Controller) (err error) {
 		return err
 	}
 
-	index, err := NewIndexer(config.Engine, indexer.Config{
-		HostName:       config.HostName,
-		IndexDirectory: config.IndexDirectory,
-	})
+	indexConfig := config.indexConfig()
+
+	if config.RestoreFrom != "" {
+		if _, err := RestoreIndex(config.RestoreFrom, indexConfig); err != nil {
+			return c.Errf("[search]: can't restore index: %v", err)
+		}
+	}
+
+	index, err := OpenIndex(config.Engine, indexConfig)
 
 	if err != nil {
 		return err
@@ -45,29 +51,93 @@ func Setup(c *caddy.Controller) (err error) {
 	ppl, err := NewPipeline(config, index)
 
 	if err != nil {
+		ReleaseIndex(indexConfig)
 		return err
 	}
 
-	expire := time.NewTicker(config.Expire)
+	ppl.Crawl = LoadCrawlState(config.CrawlStateFile(), config.Fingerprint())
+
+	search := &Search{
+		Config:   config,
+		Indexer:  index,
+		Pipeline: ppl,
+		stop:     make(chan struct{}),
+	}
+
+	if config.Recrawl {
+		search.recrawler = NewRecrawler(search, config.RecrawlConcurrency, config.RecrawlRate)
+		ppl.Indexed = func(path string) {
+			search.recrawler.Track(path, time.Now())
+		}
+	}
+
+	// sites sharing an index and a root only read its files once
+	scans := claimScan(search)
+
+	if config.Watch && scans {
+		// without a watcher the periodic scans still pick up the changes
+		watcher, werr := NewWatcher(config.SiteRoot, config.WatchDebounce, ppl, index)
+		if werr != nil {
+			ppl.Stats.Error(werr)
+		}
+		search.watcher = watcher
+	}
+
 	go func() {
-		var lastScanned indexer.Record
-		lastScanned = ScanToPipe(config.SiteRoot, ppl, index)
+		if !scans {
+			return
+		}
+		ScanToPipe(config.SiteRoot, ppl, index)
+
+		if config.Rescan <= 0 {
+			return
+		}
+
+		rescan := time.NewTicker(config.Rescan)
+		defer rescan.Stop()
 
 		for {
 			select {
-			case <-expire.C:
-				if lastScanned != nil && (!lastScanned.Indexed().IsZero() || lastScanned.Ignored()) {
-					lastScanned = ScanToPipe(config.SiteRoot, ppl, index)
+			case <-rescan.C:
+				// wait for the previous scan to get through the pipeline
+				if ppl.Stats.Queued() == 0 {
+					ScanToPipe(config.SiteRoot, ppl, index)
 				}
+			case <-search.stop:
+				return
 			}
 		}
 	}()
 
-	search := &Search{
-		Config:   config,
-		Indexer:  index,
-		Pipeline: ppl,
-	}
+	registerSite(config.Host, search)
+
+	// On restart the queued documents are flushed, then the new instance is
+	// set up before this one shuts down: it reopens the index while it's
+	// still held here and gets the same handler.
+	c.OnRestart(func() error {
+		ppl.Wait(drainTimeout)
+		return nil
+	})
+	// internal requests need the rest of the handler chain, which is only
+	// complete once the server starts
+	c.OnStartup(func() error {
+		if search.recrawler != nil {
+			search.recrawler.Start()
+		}
+		if len(config.Sitemaps) > 0 {
+			go search.repeat(config.CrawlInterval, search.CrawlSitemaps)
+		}
+		if len(config.CrawlSeeds) > 0 {
+			go search.repeat(config.CrawlInterval, search.CrawlLinks)
+		}
+		return nil
+	})
+	c.OnShutdown(func() error {
+		search.Stop()
+		unregisterSite(config.Host, search)
+		releaseScan(search)
+		return ReleaseIndex(indexConfig)
+	})
 
 	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
 		search.Next = next
@@ -80,13 +150,35 @@ func Setup(c *caddy.Controller) (err error) {
 // ScanToPipe ...
 func ScanToPipe(fp string, pipeline *Pipeline, index indexer.Handler) indexer.Record {
 	var last indexer.Record
+	pipeline.Stats.ScanStarted()
+	defer pipeline.Stats.ScanFinished()
+
+	if pipeline.Crawl != nil {
+		if err := pipeline.Crawl.Save(); err != nil {
+			pipeline.Stats.Error(err)
+		}
+	}
+
+	// documents stored the way older versions did are only looked for once
+	pipeline.staleOnce.Do(pipeline.removeStale)
+
+	// seen holds the valid paths found. Documents are only removed after a
+	// complete walk: a directory that can't be read doesn't mean its files
+	// are gone.
+	seen := make(map[string]bool)
+	complete := true
+
 	absPath, _ := filepath.Abs(fp)
 	filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			complete = false
+			return nil
+		}
 		if info.Name() == "." {
 			return nil
 		}
 
-		if info.Name() == "" || info.Name()[0] == '.' {
+		if hidden(info.Name()) {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
@@ -101,10 +193,15 @@ func ScanToPipe(fp string, pipeline *Pipeline, index indexer.Handler) indexer.Re
 			reqPath = "/" + reqPath
 
 			if pipeline.ValidatePath(reqPath) {
-				record := index.Record(reqPath)
-				record.SetFullPath(path)
-				record.SetModified(info.ModTime())
-				pipeline.Pipe(record)
+				seen[reqPath] = true
+			}
+
+			if pipeline.Crawl != nil && pipeline.ValidatePath(reqPath) && !pipeline.Crawl.Changed(reqPath, info) {
+				pipeline.skip(reqPath)
+				return nil
+			}
+
+			if record := PipeFile(reqPath, path, info, pipeline, index); record != nil {
 				last = record
 			}
 		}
@@ -112,12 +209,30 @@ func ScanToPipe(fp string, pipeline *Pipeline, index indexer.Handler) indexer.Re
 		return nil
 	})
 
+	if complete {
+		pipeline.removeMissing(seen)
+	}
+
 	return last
 }
 
+// PipeFile pipes the static file served at reqPath if it can be indexed
+func PipeFile(reqPath, fullPath string, info os.FileInfo, pipeline *Pipeline, index indexer.Handler) indexer.Record {
+	if !pipeline.ValidatePath(reqPath) {
+		return nil
+	}
+
+	record := index.Record(reqPath)
+	record.SetFullPath(fullPath)
+	record.SetModified(info.ModTime())
+	record.SetField(SourceField, SourceStatic)
+	pipeline.Pipe(record)
+	return record
+}
+
 // NewIndexer creates a new Indexer with the received config
 func NewIndexer(engine string, config indexer.Config) (index indexer.Handler, err error) {
-	name := filepath.Clean(config.IndexDirectory + string(filepath.Separator) + config.HostName)
+	name := indexPath(config)
 	switch engine {
 	default:
 		index, err = bleve.New(name)
@@ -127,34 +242,90 @@ func NewIndexer(engine string, config indexer.Config) (index indexer.Handler, er
 
 // Config represents this middleware configuration structure
 type Config struct {
-	HostName       string
-	Engine         string
-	Path           string
-	IncludePaths   []*regexp.Regexp
-	ExcludePaths   []*regexp.Regexp
-	Endpoint       string
-	IndexDirectory string
-	Template       *template.Template
-	Expire         time.Duration
-	SiteRoot       string
+	Host               string
+	HostName           string
+	Engine             string
+	Path               string
+	IncludePaths       []*regexp.Regexp
+	ExcludePaths       []*regexp.Regexp
+	Robots             *Robots
+	Endpoint           string
+	IndexDirectory     string
+	Template           *template.Template
+	Expire             time.Duration
+	SiteRoot           string
+	Mode               string
+	Operator           string
+	Pinned             *Pins
+	Collapse           string
+	CollapseDepth      int
+	Duplicates         string
+	DuplicateDistance  int
+	AdminEndpoint      string
+	AdminToken         string
+	MetricsEndpoint    string
+	RestoreFrom        string
+	Watch              bool
+	WatchDebounce      time.Duration
+	Rescan             time.Duration
+	Recrawl            bool
+	RecrawlConcurrency int
+	RecrawlRate        float64
+	StripParams        []string
+	Sitemaps           []string
+	CrawlInterval      time.Duration
+	CrawlSeeds         []string
+	CrawlDepth         int
+	CrawlDelay         time.Duration
+	AccessRules        []AccessRule
+	Federate           []string
+}
+
+// NewConfig creates a config with the default values for the site. The host
+// is the site's, as SiteHost returns it; its index is named after the host
+// without its port.
+func NewConfig(host, root string) *Config {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+
+	return &Config{
+		Host:               host,
+		HostName:           IndexName(name),
+		Engine:             `bleve`,
+		IndexDirectory:     `/tmp/caddyIndex`,
+		IncludePaths:       []*regexp.Regexp{},
+		ExcludePaths:       []*regexp.Regexp{},
+		Endpoint:           `/search`,
+		SiteRoot:           root,
+		Expire:             60 * time.Second,
+		Template:           nil,
+		Mode:               indexer.SimpleMode,
+		Operator:           indexer.OperatorAnd,
+		CollapseDepth:      1,
+		DuplicateDistance:  3,
+		WatchDebounce:      500 * time.Millisecond,
+		Rescan:             -1,
+		RecrawlConcurrency: 2,
+		RecrawlRate:        1,
+		CrawlInterval:      time.Hour,
+		CrawlDepth:         3,
+		CrawlDelay:         time.Second,
+	}
+}
+
+// indexConfig returns the config of the site's index
+func (c *Config) indexConfig() indexer.Config {
+	return indexer.Config{
+		HostName:       c.HostName,
+		IndexDirectory: c.IndexDirectory,
+	}
 }
 
 // ParseSearchConfig controller information to create a IndexSearch config
 func ParseSearchConfig(c *caddy.Controller, cnf *httpserver.SiteConfig) (*Config, error) {
-	hosthash := md5.New()
-	hosthash.Write([]byte(cnf.Host()))
-
-	conf := &Config{
-		HostName:       hex.EncodeToString(hosthash.Sum(nil)),
-		Engine:         `bleve`,
-		IndexDirectory: `/tmp/caddyIndex`,
-		IncludePaths:   []*regexp.Regexp{},
-		ExcludePaths:   []*regexp.Regexp{},
-		Endpoint:       `/search`,
-		SiteRoot:       cnf.Root,
-		Expire:         60 * time.Second,
-		Template:       nil,
-	}
+	conf := NewConfig(siteHost(cnf), cnf.Root)
 
 	_, err := os.Stat(conf.SiteRoot)
 	if err != nil {
@@ -213,6 +384,224 @@ func ParseSearchConfig(c *caddy.Controller, cnf *httpserver.SiteConfig) (*Config
 					return nil, c.ArgErr()
 				}
 				conf.IndexDirectory = c.Val()
+			case "mode":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch c.Val() {
+				case indexer.SimpleMode, indexer.AdvancedMode:
+					conf.Mode = c.Val()
+				default:
+					return nil, c.Errf("[search]: unknown mode '%s'", c.Val())
+				}
+			case "operator":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch op := strings.ToLower(c.Val()); op {
+				case indexer.OperatorAnd, indexer.OperatorOr:
+					conf.Operator = op
+				default:
+					return nil, c.Errf("[search]: unknown operator '%s'", c.Val())
+				}
+			case "collapse":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch c.Val() {
+				case CollapseURL, CollapseDir:
+					conf.Collapse = c.Val()
+				default:
+					return nil, c.Errf("[search]: unknown collapse mode '%s'", c.Val())
+				}
+				if c.NextArg() {
+					depth, err := strconv.Atoi(c.Val())
+					if err != nil || depth < 1 {
+						return nil, c.Errf("[search]: invalid collapse depth '%s'", c.Val())
+					}
+					conf.CollapseDepth = depth
+				}
+			case "duplicates":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch c.Val() {
+				case DuplicatesSkip, DuplicatesCluster:
+					conf.Duplicates = c.Val()
+				default:
+					return nil, c.Errf("[search]: unknown duplicates mode '%s'", c.Val())
+				}
+				if c.NextArg() {
+					distance, err := strconv.Atoi(c.Val())
+					if err != nil || distance < 0 || distance > 64 {
+						return nil, c.Errf("[search]: invalid duplicates distance '%s'", c.Val())
+					}
+					conf.DuplicateDistance = distance
+				}
+			case "visibility":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				path, err := regexp.Compile(c.Val())
+				if err != nil {
+					return nil, c.Errf("[search]: invalid visibility path '%s'", c.Val())
+				}
+				users := c.RemainingArgs()
+				if len(users) == 0 {
+					return nil, c.ArgErr()
+				}
+				conf.AccessRules = append(conf.AccessRules, AccessRule{Path: path, Users: users})
+			case "federate":
+				hosts := c.RemainingArgs()
+				if len(hosts) == 0 {
+					return nil, c.ArgErr()
+				}
+				conf.Federate = append(conf.Federate, hosts...)
+			case "index":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				name := c.Val()
+				if name == "." || name == ".." || name != filepath.Base(name) {
+					return nil, c.Errf("[search]: invalid index name '%s'", name)
+				}
+				conf.HostName = name
+			case "watch":
+				conf.Watch = true
+				if c.NextArg() {
+					ms, err := strconv.Atoi(c.Val())
+					if err != nil || ms < 0 {
+						return nil, c.Errf("[search]: invalid watch debounce '%s'", c.Val())
+					}
+					conf.WatchDebounce = time.Duration(ms) * time.Millisecond
+				}
+			case "rescan":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				if c.Val() == "off" {
+					conf.Rescan = 0
+					break
+				}
+				secs, err := strconv.Atoi(c.Val())
+				if err != nil || secs <= 0 {
+					return nil, c.Errf("[search]: invalid rescan interval '%s'", c.Val())
+				}
+				conf.Rescan = time.Duration(secs) * time.Second
+			case "recrawl":
+				conf.Recrawl = true
+				args := c.RemainingArgs()
+				if len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				if len(args) > 0 {
+					if args[0] == "off" && len(args) == 1 {
+						conf.Recrawl = false
+						break
+					}
+					n, err := strconv.Atoi(args[0])
+					if err != nil || n <= 0 {
+						return nil, c.Errf("[search]: invalid recrawl concurrency '%s'", args[0])
+					}
+					conf.RecrawlConcurrency = n
+				}
+				if len(args) > 1 {
+					rate, err := strconv.ParseFloat(args[1], 64)
+					if err != nil || rate <= 0 || rate > maxRecrawlRate {
+						return nil, c.Errf("[search]: invalid recrawl rate '%s'", args[1])
+					}
+					conf.RecrawlRate = rate
+				}
+			case "strip_params":
+				params := c.RemainingArgs()
+				if len(params) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, param := range params {
+					if _, err := path.Match(param, ""); err != nil {
+						return nil, c.Errf("[search]: invalid parameter pattern '%s'", param)
+					}
+				}
+				conf.StripParams = append(conf.StripParams, params...)
+			case "sitemap":
+				paths := c.RemainingArgs()
+				if len(paths) == 0 {
+					paths = []string{"/sitemap.xml"}
+				}
+				conf.Sitemaps = append(conf.Sitemaps, paths...)
+			case "crawl_interval":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				if c.Val() == "off" {
+					conf.CrawlInterval = 0
+					break
+				}
+				secs, err := strconv.Atoi(c.Val())
+				if err != nil || secs <= 0 {
+					return nil, c.Errf("[search]: invalid crawl interval '%s'", c.Val())
+				}
+				conf.CrawlInterval = time.Duration(secs) * time.Second
+			case "crawl":
+				seeds := c.RemainingArgs()
+				if len(seeds) == 0 {
+					seeds = []string{"/"}
+				}
+				conf.CrawlSeeds = append(conf.CrawlSeeds, seeds...)
+			case "crawl_depth":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				depth, err := strconv.Atoi(c.Val())
+				if err != nil || depth < 0 {
+					return nil, c.Errf("[search]: invalid crawl depth '%s'", c.Val())
+				}
+				conf.CrawlDepth = depth
+			case "crawl_delay":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				ms, err := strconv.Atoi(c.Val())
+				if err != nil || ms < 0 {
+					return nil, c.Errf("[search]: invalid crawl delay '%s'", c.Val())
+				}
+				conf.CrawlDelay = time.Duration(ms) * time.Millisecond
+			case "restore_from":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				conf.RestoreFrom = c.Val()
+			case "metrics":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				conf.MetricsEndpoint = c.Val()
+			case "admin":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				conf.AdminEndpoint, conf.AdminToken = args[0], args[1]
+			case "robots":
+				file := "robots.txt"
+				if c.NextArg() {
+					file = c.Val()
+				}
+				f, err := os.Open(sitePath(conf.SiteRoot, file))
+				if err != nil {
+					return nil, c.Errf("[search]: invalid robots file: %v", err)
+				}
+				conf.Robots = ParseRobots(f, robotsAgent)
+				f.Close()
+			case "pinned":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				pins, err := NewPins(sitePath(conf.SiteRoot, c.Val()))
+				if err != nil {
+					return nil, c.Errf("[search]: invalid pinned file: %v", err)
+				}
+				conf.Pinned = pins
 			case "template":
 				var err error
 				if c.NextArg() {
@@ -229,6 +618,10 @@ func ParseSearchConfig(c *caddy.Controller, cnf *httpserver.SiteConfig) (*Config
 		incPaths = append(incPaths, "^/")
 	}
 
+	if conf.Rescan < 0 {
+		conf.Rescan = conf.Expire
+	}
+
 	conf.IncludePaths = ConvertToRegExp(incPaths)
 	conf.ExcludePaths = ConvertToRegExp(excPaths)
 
@@ -250,6 +643,15 @@ func ParseSearchConfig(c *caddy.Controller, cnf *httpserver.SiteConfig) (*Config
 	return conf, nil
 }
 
+// sitePath resolves a configured file path relative to the site root unless
+// it is absolute
+func sitePath(root, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(root, p)
+}
+
 // ConvertToRegExp compile a string regular expression to multiple *regexp.Regexp instances
 func ConvertToRegExp(rexp []string) (r []*regexp.Regexp) {
 	r = make([]*regexp.Regexp, 0)
@@ -308,6 +710,18 @@ li {
 	font-size: 18px;
 }
 
+.result-promoted {
+	font-size: 12px;
+	margin-left: 5px;
+	color: #080;
+}
+
+.result-more {
+	font-size: 13px;
+	margin-top: 5px;
+	color: #777;
+}
+
 .result-url {
 	font-size: 14px;
 	margin-bottom: 5px;
@@ -334,9 +748,10 @@ li {
 		<ol>
 			{{range .Results}}
 			<li>
-				<div class="result-title"><a href="{{.Path}}">{{.Title}}</a></div>
-				<div class="result-url">{{$.Req.Host}}{{.Path}}</div>
+				<div class="result-title"><a href="{{.URL}}">{{.Title}}</a>{{if .Promoted}}<span class="result-promoted">Recommended</span>{{end}}</div>
+				<div class="result-url">{{if .Host}}{{.Host}}{{else}}{{$.Req.Host}}{{end}}{{.Path}}</div>
 				{{.Body}}
+				{{if .Collapsed}}<div class="result-more">{{.Collapsed}} more from this section</div>{{end}}
 			</li>
 			{{end}}
 		</ol>
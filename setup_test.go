@@ -90,6 +90,270 @@ var (
 				So(expected.Expire, ShouldEqual, result.Expire)
 			},
 		},
+		{
+			`search {
+				mode advanced
+				operator OR
+			}`,
+			search.Config{
+				Mode:     "advanced",
+				Operator: "or",
+			},
+			"Should `search` support query mode and default operator",
+			func(expected, result search.Config) {
+				So(expected.Mode, ShouldEqual, result.Mode)
+				So(expected.Operator, ShouldEqual, result.Operator)
+			},
+		},
+		{
+			`search {
+				collapse dir 2
+			}`,
+			search.Config{
+				Collapse:      "dir",
+				CollapseDepth: 2,
+			},
+			"Should `search` support result collapsing",
+			func(expected, result search.Config) {
+				So(expected.Collapse, ShouldEqual, result.Collapse)
+				So(expected.CollapseDepth, ShouldEqual, result.CollapseDepth)
+			},
+		},
+		{
+			`search {
+				duplicates skip 5
+				admin /search-admin secret
+			}`,
+			search.Config{
+				Duplicates:        "skip",
+				DuplicateDistance: 5,
+				AdminEndpoint:     "/search-admin",
+			},
+			"Should `search` support near-duplicate detection and the admin endpoint",
+			func(expected, result search.Config) {
+				So(expected.Duplicates, ShouldEqual, result.Duplicates)
+				So(expected.DuplicateDistance, ShouldEqual, result.DuplicateDistance)
+				So(expected.AdminEndpoint, ShouldEqual, result.AdminEndpoint)
+			},
+		},
+		{
+			`search {
+				admin /search-admin secret
+			}`,
+			search.Config{
+				AdminEndpoint: "/search-admin",
+				AdminToken:    "secret",
+			},
+			"Should `search` support a token for the admin endpoint",
+			func(expected, result search.Config) {
+				So(expected.AdminEndpoint, ShouldEqual, result.AdminEndpoint)
+				So(expected.AdminToken, ShouldEqual, result.AdminToken)
+			},
+		},
+		{
+			`search {
+				visibility ^/admin/ alice bob
+				visibility ^/members/ *
+			}`,
+			search.Config{
+				AccessRules: []search.AccessRule{
+					{Path: search.ConvertToRegExp([]string{"^/admin/"})[0], Users: []string{"alice", "bob"}},
+					{Path: search.ConvertToRegExp([]string{"^/members/"})[0], Users: []string{"*"}},
+				},
+			},
+			"Should `search` support visibility rules",
+			func(expected, result search.Config) {
+				So(len(result.AccessRules), ShouldEqual, 2)
+				So(expected.AccessRules[0].Path.String(), ShouldEqual, result.AccessRules[0].Path.String())
+				So(expected.AccessRules[0].Users, ShouldResemble, result.AccessRules[0].Users)
+				So(expected.AccessRules[1].Users, ShouldResemble, result.AccessRules[1].Users)
+			},
+		},
+		{
+			`search {
+				federate docs.example.com blog.example.com:8080
+			}`,
+			search.Config{
+				Federate: []string{"docs.example.com", "blog.example.com:8080"},
+			},
+			"Should `search` support federated sites",
+			func(expected, result search.Config) {
+				So(expected.Federate, ShouldResemble, result.Federate)
+			},
+		},
+		{
+			`search {
+				index shared
+			}`,
+			search.Config{
+				HostName: "shared",
+			},
+			"Should `search` support named indexes",
+			func(expected, result search.Config) {
+				So(expected.HostName, ShouldEqual, result.HostName)
+			},
+		},
+		{
+			`search {
+				metrics /metrics
+			}`,
+			search.Config{
+				MetricsEndpoint: "/metrics",
+			},
+			"Should `search` support the metrics endpoint",
+			func(expected, result search.Config) {
+				So(expected.MetricsEndpoint, ShouldEqual, result.MetricsEndpoint)
+			},
+		},
+		{
+			`search {
+				restore_from /var/backups/index.tar.gz
+			}`,
+			search.Config{
+				RestoreFrom: "/var/backups/index.tar.gz",
+			},
+			"Should `search` support restoring from a snapshot",
+			func(expected, result search.Config) {
+				So(expected.RestoreFrom, ShouldEqual, result.RestoreFrom)
+			},
+		},
+		{
+			`search {
+				expire 120
+				watch 250
+			}`,
+			search.Config{
+				Watch:         true,
+				WatchDebounce: 250 * time.Millisecond,
+				Rescan:        120 * time.Second,
+			},
+			"Should `search` support watching with the periodic scan as fallback",
+			func(expected, result search.Config) {
+				So(expected.Watch, ShouldEqual, result.Watch)
+				So(expected.WatchDebounce, ShouldEqual, result.WatchDebounce)
+				So(expected.Rescan, ShouldEqual, result.Rescan)
+			},
+		},
+		{
+			`search {
+				watch
+				rescan off
+			}`,
+			search.Config{
+				Watch:         true,
+				WatchDebounce: 500 * time.Millisecond,
+				Rescan:        0,
+			},
+			"Should `search` support disabling the periodic scan",
+			func(expected, result search.Config) {
+				So(expected.WatchDebounce, ShouldEqual, result.WatchDebounce)
+				So(expected.Rescan, ShouldEqual, result.Rescan)
+			},
+		},
+		{
+			`search {
+				recrawl 4 0.5
+			}`,
+			search.Config{
+				Recrawl:            true,
+				RecrawlConcurrency: 4,
+				RecrawlRate:        0.5,
+			},
+			"Should `search` support recrawling dynamic pages with limits",
+			func(expected, result search.Config) {
+				So(expected.Recrawl, ShouldEqual, result.Recrawl)
+				So(expected.RecrawlConcurrency, ShouldEqual, result.RecrawlConcurrency)
+				So(expected.RecrawlRate, ShouldEqual, result.RecrawlRate)
+			},
+		},
+		{
+			`search {
+				recrawl
+			}`,
+			search.Config{
+				Recrawl:            true,
+				RecrawlConcurrency: 2,
+				RecrawlRate:        1,
+			},
+			"Should `search` recrawl with the default limits",
+			func(expected, result search.Config) {
+				So(expected.Recrawl, ShouldEqual, result.Recrawl)
+				So(expected.RecrawlConcurrency, ShouldEqual, result.RecrawlConcurrency)
+				So(expected.RecrawlRate, ShouldEqual, result.RecrawlRate)
+			},
+		},
+		{
+			`search {
+				sitemap
+				sitemap /news/sitemap.xml /blog/sitemap.xml.gz
+			}`,
+			search.Config{
+				Sitemaps: []string{"/sitemap.xml", "/news/sitemap.xml", "/blog/sitemap.xml.gz"},
+			},
+			"Should `search` support sitemaps",
+			func(expected, result search.Config) {
+				So(expected.Sitemaps, ShouldResemble, result.Sitemaps)
+			},
+		},
+		{
+			`search {
+				sitemap
+				crawl_interval 600
+			}`,
+			search.Config{
+				Sitemaps:      []string{"/sitemap.xml"},
+				CrawlInterval: 600 * time.Second,
+			},
+			"Should `search` support the interval of the sitemap crawls",
+			func(expected, result search.Config) {
+				So(expected.CrawlInterval, ShouldEqual, result.CrawlInterval)
+			},
+		},
+		{
+			`search {
+				sitemap
+				crawl_interval off
+			}`,
+			search.Config{
+				Sitemaps:      []string{"/sitemap.xml"},
+				CrawlInterval: 0,
+			},
+			"Should `search` support only crawling the sitemaps at startup",
+			func(expected, result search.Config) {
+				So(expected.CrawlInterval, ShouldEqual, result.CrawlInterval)
+			},
+		},
+		{
+			`search {
+				strip_params utm_* fbclid
+				strip_params sessionid
+			}`,
+			search.Config{
+				StripParams: []string{"utm_*", "fbclid", "sessionid"},
+			},
+			"Should `search` support stripping query parameters",
+			func(expected, result search.Config) {
+				So(expected.StripParams, ShouldResemble, result.StripParams)
+			},
+		},
+		{
+			`search {
+				crawl / /archive
+				crawl_depth 5
+				crawl_delay 250
+			}`,
+			search.Config{
+				CrawlSeeds: []string{"/", "/archive"},
+				CrawlDepth: 5,
+				CrawlDelay: 250 * time.Millisecond,
+			},
+			"Should `search` support crawling links from seeds",
+			func(expected, result search.Config) {
+				So(expected.CrawlSeeds, ShouldResemble, result.CrawlSeeds)
+				So(expected.CrawlDepth, ShouldEqual, result.CrawlDepth)
+				So(expected.CrawlDelay, ShouldEqual, result.CrawlDelay)
+			},
+		},
 	}
 )
 
@@ -108,3 +372,20 @@ func TestSearchSetup(t *testing.T) {
 		})
 	}
 }
+
+func TestSiteHost(t *testing.T) {
+	Convey("Given the address of a site", t, func() {
+		Convey("Should keep the port unless it's the default one", func() {
+			So(search.SiteHost("example.com"), ShouldEqual, "example.com")
+			So(search.SiteHost("example.com:443"), ShouldEqual, "example.com")
+			So(search.SiteHost("example.com:8080"), ShouldEqual, "example.com:8080")
+		})
+
+		Convey("Should name the index after the host without its port", func() {
+			config := search.NewConfig(search.SiteHost("example.com:8080"), "")
+			So(config.Host, ShouldEqual, "example.com:8080")
+			So(config.HostName, ShouldEqual, search.IndexName("example.com"))
+			So(config.CrawlStateFile(), ShouldNotEqual, search.NewConfig("example.com", "").CrawlStateFile())
+		})
+	})
+}
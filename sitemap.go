@@ -0,0 +1,189 @@
+package search
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sitemapLimit is the size limit of an uncompressed sitemap in the sitemap
+// protocol
+const sitemapLimit = 50 << 20
+
+// sitemapDepth bounds how deep sitemap indexes are followed
+const sitemapDepth = 3
+
+// sitemap is either a sitemap listing URLs or a sitemap index listing other
+// sitemaps
+type sitemap struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// lastModLayouts are the W3C datetime formats of <lastmod>
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// parseLastMod returns the zero time for a missing or invalid <lastmod>
+func parseLastMod(value string) time.Time {
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// CrawlSitemaps requests the pages listed by the site's sitemaps through the
+// rest of the handler chain, to index them. Pages are skipped when their
+// <lastmod> isn't newer than their indexed copy, or without <lastmod> when
+// their indexed copy hasn't expired. Requests are sent at the recrawl rate.
+func (s *Search) CrawlSitemaps() {
+	seen := make(map[string]bool)
+	var pages []sitemapEntry
+	for _, path := range s.Config.Sitemaps {
+		pages = s.readSitemap(path, sitemapDepth, seen, pages)
+	}
+
+	limiter := time.NewTicker(requestInterval(s.Config.RecrawlRate))
+	defer limiter.Stop()
+
+	for _, page := range pages {
+		path, ok := s.Config.ResolvePath("/", page.Loc)
+		if !ok || !s.Pipeline.ValidatePath(path) || !s.stale(path, parseLastMod(page.LastMod)) {
+			continue
+		}
+
+		select {
+		case <-limiter.C:
+		case <-s.stop:
+			return
+		}
+
+		r, err := s.internalRequest(path)
+		if err != nil {
+			continue
+		}
+		s.capture(newInternalResponseWriter(false), r)
+	}
+}
+
+// readSitemap appends the pages listed by the sitemap at path, following
+// sitemap indexes up to depth levels
+func (s *Search) readSitemap(path string, depth int, seen map[string]bool, pages []sitemapEntry) []sitemapEntry {
+	if depth == 0 || seen[path] {
+		return pages
+	}
+	seen[path] = true
+
+	data, err := s.loadSitemap(path)
+	if err != nil {
+		s.Pipeline.Stats.Error(fmt.Errorf("sitemap %s: %v", path, err))
+		return pages
+	}
+
+	var sm sitemap
+	if err := xml.Unmarshal(data, &sm); err != nil {
+		s.Pipeline.Stats.Error(fmt.Errorf("sitemap %s: %v", path, err))
+		return pages
+	}
+
+	pages = append(pages, sm.URLs...)
+	for _, entry := range sm.Sitemaps {
+		if nested, ok := s.Config.ResolvePath("/", entry.Loc); ok {
+			pages = s.readSitemap(nested, depth-1, seen, pages)
+		}
+	}
+	return pages
+}
+
+// loadSitemap reads the sitemap from the site root, or requests it through
+// the rest of the handler chain when it's generated. Gzipped sitemaps are
+// uncompressed.
+func (s *Search) loadSitemap(path string) ([]byte, error) {
+	var data []byte
+
+	root, err := filepath.Abs(s.Config.SiteRoot)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(root, filepath.FromSlash(filepath.Clean("/"+u.Path)))
+	if f, err := os.Open(file); err == nil {
+		data, err = ioutil.ReadAll(io.LimitReader(f, sitemapLimit+1))
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := s.internalRequest(path)
+		if err != nil {
+			return nil, err
+		}
+		w := newInternalResponseWriter(true)
+		status, err := s.Next.ServeHTTP(w, r)
+		if err != nil {
+			return nil, err
+		}
+		if w.status == 0 {
+			w.status = status
+		}
+		if w.status != http.StatusOK {
+			return nil, fmt.Errorf("status %d", w.status)
+		}
+		data = w.body.Bytes()
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(gz, sitemapLimit+1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) > sitemapLimit {
+		return nil, fmt.Errorf("larger than %d bytes", sitemapLimit)
+	}
+	return data, nil
+}
+
+// stale returns true if the page isn't indexed, or if its indexed copy is
+// older than lastmod, or has expired when lastmod is unknown
+func (s *Search) stale(path string, lastmod time.Time) bool {
+	record, err := s.Indexer.Get(path)
+	if err != nil {
+		return true
+	}
+	indexed := record.Indexed()
+	s.Indexer.Kill(record)
+
+	if !lastmod.IsZero() {
+		return lastmod.After(indexed)
+	}
+	return time.Since(indexed) >= s.Config.Expire
+}
@@ -0,0 +1,85 @@
+package search_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCrawlSitemaps(t *testing.T) {
+	Convey("Given a site with a sitemap", t, func() {
+		site := newTestSite(map[string]string{
+			"sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>http://example.com/a</loc></url>
+	<url><loc>/b</loc></url>
+	<url><loc>http://other.com/c</loc></url>
+</urlset>`,
+		})
+		Reset(site.close)
+
+		dynamic := &fakeSite{}
+		site.search.Next = dynamic
+		site.config.Sitemaps = []string{"/sitemap.xml"}
+		site.config.RecrawlRate = 100
+		site.config.Expire = 0
+
+		Convey("Should fetch and index the pages of this site it lists", func() {
+			site.search.CrawlSitemaps()
+			So(dynamic.paths(), ShouldResemble, []string{"/a", "/b"})
+			So(eventually(func() bool { return site.indexed("/a") && site.indexed("/b") }), ShouldBeTrue)
+		})
+
+		Convey("Should keep the access of the pages it refreshes", func() {
+			r := httptest.NewRequest(http.MethodGet, "/a", nil)
+			r = r.WithContext(context.WithValue(r.Context(), httpserver.RemoteUserCtxKey, "alice"))
+			site.search.ServeHTTP(httptest.NewRecorder(), r)
+			So(eventually(func() bool { return site.indexed("/a") }), ShouldBeTrue)
+
+			site.search.CrawlSitemaps()
+
+			var access string
+			refreshed := eventually(func() bool {
+				record, err := site.index.Get("/a")
+				if err != nil {
+					return false
+				}
+				defer site.index.Kill(record)
+				access = record.Field(search.AccessField)
+				return strings.Contains(string(record.Body()), "Version 2")
+			})
+			So(refreshed, ShouldBeTrue)
+			So(access, ShouldEqual, "alice")
+		})
+
+		Convey("Should refuse a generated sitemap larger than the limit", func() {
+			site.config.Sitemaps = []string{"/generated.xml"}
+			chunk := []byte(strings.Repeat(" ", 1<<20))
+			site.search.Next = httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+				if r.URL.Path != "/generated.xml" {
+					return dynamic.ServeHTTP(w, r)
+				}
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>/a</loc></url>`))
+				for i := 0; i <= 50; i++ {
+					w.Write(chunk)
+				}
+				w.Write([]byte(`</urlset>`))
+				return http.StatusOK, nil
+			})
+
+			site.search.CrawlSitemaps()
+			So(dynamic.paths(), ShouldBeEmpty)
+
+			status, err := site.search.Status()
+			So(err, ShouldBeNil)
+			So(status.LastError, ShouldNotBeNil)
+			So(status.LastError.Message, ShouldContainSubstring, "larger than")
+		})
+	})
+}
@@ -0,0 +1,236 @@
+package search
+
+import (
+	"sync"
+	"time"
+)
+
+// Reasons for ignoring a document
+const (
+	IgnoredExcluded     = "path_excluded"
+	IgnoredReadError    = "read_error"
+	IgnoredUnsupported  = "unsupported_type"
+	IgnoredStatus       = "non_200_status"
+	IgnoredDuplicate    = "duplicate"
+	IgnoredRobots       = "robots_noindex"
+	IgnoredUnchanged    = "unchanged"
+	IgnoredExtractError = "extract_error"
+)
+
+// Stats counts what happens to the documents going through a Pipeline
+type Stats struct {
+	mutex         sync.RWMutex
+	queued        int64
+	indexed       int64
+	removed       int64
+	ignored       map[string]int64
+	scanStarted   time.Time
+	scanFinished  time.Time
+	scanSkipped   int64
+	scanUpdated   int64
+	scanRemoved   int64
+	lastError     string
+	lastErrorTime time.Time
+	requests      map[string]int64
+	queryDuration *histogram
+	resultCount   *histogram
+	scanDuration  *histogram
+}
+
+// NewStats creates an empty Stats
+func NewStats() *Stats {
+	return &Stats{
+		ignored:       make(map[string]int64),
+		requests:      make(map[string]int64),
+		queryDuration: newHistogram(queryDurationBuckets),
+		resultCount:   newHistogram(resultCountBuckets),
+		scanDuration:  newHistogram(scanDurationBuckets),
+	}
+}
+
+// Query counts a search request in the format, how long its query took and
+// how many results it returned
+func (s *Stats) Query(format string, duration time.Duration, results int) {
+	s.mutex.Lock()
+	s.requests[format]++
+	s.queryDuration.observe(duration.Seconds())
+	s.resultCount.observe(float64(results))
+	s.mutex.Unlock()
+}
+
+// Queue counts documents entering (n > 0) or leaving (n < 0) the pipeline
+func (s *Stats) Queue(n int64) {
+	s.mutex.Lock()
+	s.queued += n
+	s.mutex.Unlock()
+}
+
+// Indexed counts a document sent to the indexer
+func (s *Stats) Indexed() {
+	s.mutex.Lock()
+	s.indexed++
+	s.mutex.Unlock()
+}
+
+// Removed counts a document deleted from the index
+func (s *Stats) Removed() {
+	s.mutex.Lock()
+	s.removed++
+	s.scanRemoved++
+	s.mutex.Unlock()
+}
+
+// Ignored counts a document ignored for the reason
+func (s *Stats) Ignored(reason string) {
+	s.mutex.Lock()
+	s.ignored[reason]++
+	s.mutex.Unlock()
+}
+
+// Error keeps the last indexing error
+func (s *Stats) Error(err error) {
+	s.mutex.Lock()
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+	s.mutex.Unlock()
+}
+
+// ScanStarted keeps the time the last full scan started
+func (s *Stats) ScanStarted() {
+	s.mutex.Lock()
+	s.scanStarted = time.Now()
+	s.scanFinished = time.Time{}
+	s.scanSkipped = 0
+	s.scanUpdated = 0
+	s.scanRemoved = 0
+	s.mutex.Unlock()
+}
+
+// ScanFinished keeps the time the last full scan finished walking the site
+func (s *Stats) ScanFinished() {
+	s.mutex.Lock()
+	s.scanFinished = time.Now()
+	s.scanDuration.observe(s.scanFinished.Sub(s.scanStarted).Seconds())
+	s.mutex.Unlock()
+}
+
+// Totals returns the number of documents sent to the indexer and ignored
+func (s *Stats) Totals() (indexed, ignored int64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, n := range s.ignored {
+		ignored += n
+	}
+	return s.indexed, ignored
+}
+
+// Skipped counts a static file skipped because it didn't change
+func (s *Stats) Skipped() {
+	s.mutex.Lock()
+	s.scanSkipped++
+	s.mutex.Unlock()
+}
+
+// Updated counts a static file read again because it's new or changed
+func (s *Stats) Updated() {
+	s.mutex.Lock()
+	s.scanUpdated++
+	s.mutex.Unlock()
+}
+
+// Scan returns the state of the last full scan
+func (s *Stats) Scan() ScanStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return ScanStatus{
+		Started:  s.scanStarted,
+		Finished: s.scanFinished,
+		Skipped:  s.scanSkipped,
+		Updated:  s.scanUpdated,
+		Removed:  s.scanRemoved,
+	}
+}
+
+// Queued returns the number of documents in the pipeline
+func (s *Stats) Queued() int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.queued
+}
+
+// ScanStatus is the state of the last full scan. Skipped and Updated count
+// the unchanged and the new or changed static files, Removed the documents
+// deleted since the scan started.
+type ScanStatus struct {
+	Started  time.Time
+	Finished time.Time
+	Skipped  int64
+	Updated  int64
+	Removed  int64
+}
+
+// QueueStatus is the number of documents waiting in each pipeline
+type QueueStatus struct {
+	Pipeline int64
+	Indexer  int64
+}
+
+// ErrorStatus is the last indexing error
+type ErrorStatus struct {
+	Time    time.Time
+	Message string
+}
+
+// Status is the JSON status document of a site's index
+type Status struct {
+	Documents uint64
+	IndexSize int64
+	Scan      ScanStatus
+	Queued    QueueStatus
+	Indexed   int64
+	Removed   int64
+	Ignored   map[string]int64
+	LastError *ErrorStatus
+}
+
+// status fills the pipeline's part of the status document
+func (s *Stats) status(st *Status) {
+	st.Scan = s.Scan()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	st.Queued.Pipeline = s.queued
+	st.Indexed = s.indexed
+	st.Removed = s.removed
+	st.Ignored = make(map[string]int64, len(s.ignored))
+	for reason, n := range s.ignored {
+		st.Ignored[reason] = n
+	}
+	if s.lastError != "" {
+		st.LastError = &ErrorStatus{Time: s.lastErrorTime, Message: s.lastError}
+	}
+}
+
+// Status returns the status document of the site's index
+func (s *Search) Status() (*Status, error) {
+	st := &Status{}
+	s.Pipeline.Stats.status(st)
+
+	count, err := s.Indexer.Count()
+	if err != nil {
+		return nil, err
+	}
+	st.Documents = count
+
+	is := s.Indexer.Status()
+	st.IndexSize = is.Size
+	st.Queued.Indexer = is.Queued
+	if is.LastError != "" && (st.LastError == nil || is.LastErrorTime.After(st.LastError.Time)) {
+		st.LastError = &ErrorStatus{Time: is.LastErrorTime, Message: is.LastError}
+	}
+
+	return st, nil
+}
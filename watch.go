@@ -0,0 +1,198 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pedronasser/caddy-search/indexer"
+)
+
+// Watcher watches the site root recursively and pipes the static files that
+// are created or modified, once they stop changing for the debounce delay.
+// The files deleted or renamed are removed from the index.
+type Watcher struct {
+	root     string
+	pipeline *Pipeline
+	index    indexer.Handler
+	debounce time.Duration
+	fsw      *fsnotify.Watcher
+
+	mutex   sync.Mutex
+	pending map[string]*time.Timer
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewWatcher starts watching the site root
+func NewWatcher(root string, debounce time.Duration, pipeline *Pipeline, index indexer.Handler) (*Watcher, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		root:     absRoot,
+		pipeline: pipeline,
+		index:    index,
+		debounce: debounce,
+		fsw:      fsw,
+		pending:  make(map[string]*time.Timer),
+		done:     make(chan struct{}),
+	}
+
+	if err := w.addTree(absRoot, false); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	go w.loop()
+
+	return w, nil
+}
+
+// Close stops watching. Changes waiting for their debounce delay are dropped.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.done)
+		w.fsw.Close()
+
+		w.mutex.Lock()
+		for path, timer := range w.pending {
+			timer.Stop()
+			delete(w.pending, path)
+		}
+		w.mutex.Unlock()
+	})
+}
+
+// addTree watches the directory and its subdirectories. When schedule is
+// true, the files found are scheduled too: they were moved in or created
+// before the directory was watched.
+func (w *Watcher) addTree(dir string, schedule bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != dir && hidden(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return w.fsw.Add(path)
+		}
+		if schedule {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) loop() {
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.pipeline.Stats.Error(err)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	rel, err := filepath.Rel(w.root, event.Name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if hidden(name) {
+			return
+		}
+	}
+
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := w.addTree(event.Name, true); err != nil {
+				w.pipeline.Stats.Error(err)
+			}
+			return
+		}
+	}
+
+	w.schedule(event.Name)
+}
+
+// schedule (re)starts the debounce delay of the file. A delay that already
+// expired, its callback waiting for the mutex, is replaced: the callback then
+// finds another timer pending and leaves the file to it.
+func (w *Watcher) schedule(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if timer, ok := w.pending[path]; ok && timer.Stop() {
+		timer.Reset(w.debounce)
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(w.debounce, func() {
+		w.mutex.Lock()
+		if w.pending[path] != timer {
+			w.mutex.Unlock()
+			return
+		}
+		delete(w.pending, path)
+		w.mutex.Unlock()
+
+		select {
+		case <-w.done:
+		default:
+			w.fire(path)
+		}
+	})
+	w.pending[path] = timer
+}
+
+// fire pipes the file once it stopped changing, or removes it from the index
+// if it was deleted or renamed
+func (w *Watcher) fire(path string) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		return
+	}
+	reqPath := "/" + filepath.ToSlash(rel)
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		w.pipeline.removeTree(reqPath)
+		return
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	PipeFile(reqPath, path, info, w.pipeline, w.index)
+}
+
+// hidden returns true for the names scans and watches skip
+func hidden(name string) bool {
+	return name == "" || name[0] == '.'
+}
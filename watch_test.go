@@ -0,0 +1,57 @@
+package search_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedronasser/caddy-search"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatcher(t *testing.T) {
+	Convey("Given a watched site root", t, func() {
+		site := newTestSite(map[string]string{
+			"old.html": "<title>Old</title><p>Old page</p>",
+		})
+		Reset(site.close)
+
+		search.ScanToPipe(site.root, site.pipeline, site.index)
+		site.settle()
+		So(site.indexed("/old.html"), ShouldBeTrue)
+
+		watcher, err := search.NewWatcher(site.root, 50*time.Millisecond, site.pipeline, site.index)
+		So(err, ShouldBeNil)
+		Reset(watcher.Close)
+
+		Convey("Should index the files created", func() {
+			ioutil.WriteFile(filepath.Join(site.root, "new.html"), []byte("<title>New</title><p>New page</p>"), 0644)
+			So(eventually(func() bool { return site.indexed("/new.html") }), ShouldBeTrue)
+		})
+
+		Convey("Should index the files of the directories created", func() {
+			os.MkdirAll(filepath.Join(site.root, "docs"), 0755)
+			ioutil.WriteFile(filepath.Join(site.root, "docs", "guide.html"), []byte("<title>Guide</title><p>Guide</p>"), 0644)
+			So(eventually(func() bool { return site.indexed("/docs/guide.html") }), ShouldBeTrue)
+		})
+
+		Convey("Should remove the files deleted", func() {
+			os.Remove(filepath.Join(site.root, "old.html"))
+			So(eventually(func() bool { return !site.indexed("/old.html") }), ShouldBeTrue)
+		})
+
+		Convey("Should move the files renamed", func() {
+			os.Rename(filepath.Join(site.root, "old.html"), filepath.Join(site.root, "renamed.html"))
+			So(eventually(func() bool { return site.indexed("/renamed.html") && !site.indexed("/old.html") }), ShouldBeTrue)
+		})
+
+		Convey("Should skip hidden files", func() {
+			ioutil.WriteFile(filepath.Join(site.root, ".draft.html"), []byte("<title>Draft</title><p>Draft</p>"), 0644)
+			ioutil.WriteFile(filepath.Join(site.root, "new.html"), []byte("<title>New</title><p>New page</p>"), 0644)
+			So(eventually(func() bool { return site.indexed("/new.html") }), ShouldBeTrue)
+			So(site.indexed("/.draft.html"), ShouldBeFalse)
+		})
+	})
+}